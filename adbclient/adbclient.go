@@ -0,0 +1,232 @@
+// Package adbclient talks to the adb server over its TCP protocol, without spawning the adb binary.
+//
+// Every request opens a new connection to the server: the request is sent as a 4 digit hex length
+// followed by the payload, the server answers with OKAY or FAIL (followed by a hex length prefixed message).
+// See: https://android.googlesource.com/platform/packages/modules/adb/+/refs/heads/main/SERVICES.TXT
+package adbclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultPort is the port of the adb server if ANDROID_ADB_SERVER_PORT is not set.
+const DefaultPort = 5037
+
+// ErrServerNotRunning is returned if nothing listens on the adb server port.
+var ErrServerNotRunning = errors.New("adb server is not running")
+
+// ServerError is a FAIL response of the adb server.
+type ServerError struct {
+	Request string
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("adb server rejected %s: %s", e.Request, e.Message)
+}
+
+// IsDeviceNotFound tells if the error means that the device is not (yet) known by the adb server.
+func IsDeviceNotFound(err error) bool {
+	var serverErr *ServerError
+	return errors.As(err, &serverErr) && strings.Contains(serverErr.Message, "not found")
+}
+
+// IsDeviceOffline tells if the error means that the device is known, but it is not ready to accept commands.
+func IsDeviceOffline(err error) bool {
+	var serverErr *ServerError
+	return errors.As(err, &serverErr) && (strings.Contains(serverErr.Message, "offline") ||
+		strings.Contains(serverErr.Message, "unauthorized") ||
+		strings.Contains(serverErr.Message, "authorizing") ||
+		strings.Contains(serverErr.Message, "connecting"))
+}
+
+// Client is an adb server client.
+type Client struct {
+	addr   string
+	dialer net.Dialer
+}
+
+// New creates a client for the adb server listening on localhost at the given port.
+func New(port int) *Client {
+	return &Client{addr: net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+}
+
+// Version returns the internal protocol version of the adb server (e.g. 41).
+func (c *Client) Version(ctx context.Context) (int, error) {
+	out, err := c.hostRequest(ctx, "host:version")
+	if err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.ParseInt(out, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid version (%s): %w", out, err)
+	}
+	return int(version), nil
+}
+
+// Devices returns the raw `adb devices -l` output of the server.
+func (c *Client) Devices(ctx context.Context) (string, error) {
+	return c.hostRequest(ctx, "host:devices-l")
+}
+
+// State returns the state of the device (e.g. device, offline, unauthorized).
+func (c *Client) State(ctx context.Context, serial string) (string, error) {
+	return c.hostRequest(ctx, "host-serial:"+serial+":get-state")
+}
+
+// Connect asks the server to connect to a network device (host:port), it returns the server's reply
+// (e.g. "connected to 10.0.0.2:5555" or "failed to connect to 10.0.0.2:5555").
+func (c *Client) Connect(ctx context.Context, target string) (string, error) {
+	return c.hostRequest(ctx, "host:connect:"+target)
+}
+
+// Disconnect asks the server to disconnect from a network device (host:port).
+func (c *Client) Disconnect(ctx context.Context, target string) (string, error) {
+	return c.hostRequest(ctx, "host:disconnect:"+target)
+}
+
+// Shell runs the command in the shell of the device and returns its combined output.
+// The exit code of the command is not reported by this protocol.
+func (c *Client) Shell(ctx context.Context, serial, command string) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer closeConn(conn)
+
+	if err := request(conn, "host:transport:"+serial); err != nil {
+		return "", wrapNetError(ctx, err)
+	}
+	if err := request(conn, "shell:"+command); err != nil {
+		return "", wrapNetError(ctx, err)
+	}
+
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return "", wrapNetError(ctx, err)
+	}
+	return strings.TrimSpace(strings.ReplaceAll(string(out), "\r\n", "\n")), nil
+}
+
+// hostRequest sends a host service request and reads its length prefixed reply.
+func (c *Client) hostRequest(ctx context.Context, req string) (string, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer closeConn(conn)
+
+	if err := request(conn, req); err != nil {
+		return "", wrapNetError(ctx, err)
+	}
+
+	out, err := readMessage(conn)
+	if err != nil {
+		return "", wrapNetError(ctx, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("%w: %s", ErrServerNotRunning, err)
+		}
+		return nil, wrapNetError(ctx, err)
+	}
+
+	// The deadline of the context applies to every read and write of the request.
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			closeConn(conn)
+			return nil, err
+		}
+	}
+	// Unblock pending reads and writes on cancellation.
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	return &contextConn{Conn: conn, stop: stop}, nil
+}
+
+// contextConn stops watching the context of the request when the connection is closed.
+type contextConn struct {
+	net.Conn
+	stop func() bool
+}
+
+func (c *contextConn) Close() error {
+	c.stop()
+	return c.Conn.Close()
+}
+
+// request sends a request and reads the OKAY/FAIL status of it.
+func request(conn net.Conn, req string) error {
+	if _, err := fmt.Fprintf(conn, "%04x%s", len(req), req); err != nil {
+		return err
+	}
+
+	status := make([]byte, 4)
+	if _, err := io.ReadFull(conn, status); err != nil {
+		return fmt.Errorf("read status of %s: %w", req, err)
+	}
+
+	switch string(status) {
+	case "OKAY":
+		return nil
+	case "FAIL":
+		message, err := readMessage(conn)
+		if err != nil {
+			return fmt.Errorf("read failure message of %s: %w", req, err)
+		}
+		return &ServerError{Request: req, Message: message}
+	default:
+		return fmt.Errorf("unexpected status of %s: %q", req, status)
+	}
+}
+
+func readMessage(conn net.Conn) (string, error) {
+	lengthHex := make([]byte, 4)
+	if _, err := io.ReadFull(conn, lengthHex); err != nil {
+		return "", err
+	}
+
+	length, err := strconv.ParseUint(string(lengthHex), 16, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid message length (%q): %w", lengthHex, err)
+	}
+
+	message := make([]byte, length)
+	if _, err := io.ReadFull(conn, message); err != nil {
+		return "", err
+	}
+	return string(message), nil
+}
+
+// wrapNetError tells apart the I/O errors caused by the context of the request, so callers can check them with errors.Is.
+func wrapNetError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("%s: %w", err, ctxErr)
+	}
+	// The connection deadline can fire slightly before the context notices its own deadline.
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return fmt.Errorf("%s: %w", err, context.DeadlineExceeded)
+	}
+	return err
+}
+
+func closeConn(conn net.Conn) {
+	// The connection is used for a single request, the result of closing it is irrelevant.
+	_ = conn.Close()
+}
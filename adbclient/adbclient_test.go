@@ -0,0 +1,247 @@
+package adbclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakeServer accepts connections on a random local port and handles each of them with handle.
+func fakeServer(t *testing.T, handle func(conn net.Conn)) *Client {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				handle(conn)
+			}()
+		}
+	}()
+
+	return &Client{addr: ln.Addr().String()}
+}
+
+// readRequest reads a request framed as a 4 digit hex length followed by the payload.
+func readRequest(t *testing.T, conn net.Conn) string {
+	t.Helper()
+
+	lengthHex := make([]byte, 4)
+	if _, err := io.ReadFull(conn, lengthHex); err != nil {
+		t.Errorf("read request length: %s", err)
+		return ""
+	}
+	length, err := strconv.ParseUint(string(lengthHex), 16, 16)
+	if err != nil {
+		t.Errorf("invalid request length (%q): %s", lengthHex, err)
+		return ""
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(conn, payload); err != nil {
+		t.Errorf("read request payload: %s", err)
+		return ""
+	}
+	return string(payload)
+}
+
+func writeOkay(conn net.Conn, message string) {
+	_, _ = fmt.Fprintf(conn, "OKAY%04x%s", len(message), message)
+}
+
+func writeFail(conn net.Conn, message string) {
+	_, _ = fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+}
+
+func TestClient_RequestFraming(t *testing.T) {
+	received := make(chan []byte, 1)
+	client := fakeServer(t, func(conn net.Conn) {
+		raw := make([]byte, len("000ehost:devices-l"))
+		_, _ = io.ReadFull(conn, raw)
+		received <- raw
+		writeOkay(conn, "emulator-5554          device product:sdk_gphone64_x86_64\n")
+	})
+
+	out, err := client.Devices(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(<-received); got != "000ehost:devices-l" {
+		t.Errorf("request = %q, want %q", got, "000ehost:devices-l")
+	}
+	if want := "emulator-5554          device product:sdk_gphone64_x86_64"; out != want {
+		t.Errorf("devices = %q, want %q", out, want)
+	}
+}
+
+func TestClient_Version(t *testing.T) {
+	client := fakeServer(t, func(conn net.Conn) {
+		if req := readRequest(t, conn); req != "host:version" {
+			t.Errorf("request = %q, want host:version", req)
+		}
+		writeOkay(conn, "0029")
+	})
+
+	version, err := client.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 41 {
+		t.Errorf("version = %d, want 41", version)
+	}
+}
+
+func TestClient_State(t *testing.T) {
+	client := fakeServer(t, func(conn net.Conn) {
+		switch req := readRequest(t, conn); req {
+		case "host-serial:emulator-5554:get-state":
+			writeOkay(conn, "device")
+		case "host-serial:emulator-5556:get-state":
+			writeFail(conn, "device offline")
+		default:
+			writeFail(conn, "device 'emulator-5558' not found")
+		}
+	})
+
+	tests := []struct {
+		serial      string
+		wantState   string
+		wantOffline bool
+		wantMissing bool
+	}{
+		{serial: "emulator-5554", wantState: "device"},
+		{serial: "emulator-5556", wantOffline: true},
+		{serial: "emulator-5558", wantMissing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serial, func(t *testing.T) {
+			state, err := client.State(context.Background(), tt.serial)
+			if state != tt.wantState {
+				t.Errorf("state = %q, want %q", state, tt.wantState)
+			}
+			if got := IsDeviceOffline(err); got != tt.wantOffline {
+				t.Errorf("IsDeviceOffline(%v) = %t, want %t", err, got, tt.wantOffline)
+			}
+			if got := IsDeviceNotFound(err); got != tt.wantMissing {
+				t.Errorf("IsDeviceNotFound(%v) = %t, want %t", err, got, tt.wantMissing)
+			}
+			var serverErr *ServerError
+			if (tt.wantOffline || tt.wantMissing) && !errors.As(err, &serverErr) {
+				t.Errorf("expected a server error, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestClient_Shell(t *testing.T) {
+	client := fakeServer(t, func(conn net.Conn) {
+		if req := readRequest(t, conn); req != "host:transport:emulator-5554" {
+			t.Errorf("transport request = %q", req)
+			writeFail(conn, "unexpected request")
+			return
+		}
+		_, _ = io.WriteString(conn, "OKAY")
+
+		if req := readRequest(t, conn); req != "shell:getprop sys.boot_completed" {
+			t.Errorf("shell request = %q", req)
+			writeFail(conn, "unexpected request")
+			return
+		}
+		// The shell service streams the raw output until it closes the connection.
+		_, _ = io.WriteString(conn, "OKAY1\r\n")
+	})
+
+	out, err := client.Shell(context.Background(), "emulator-5554", "getprop sys.boot_completed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "1" {
+		t.Errorf("output = %q, want %q", out, "1")
+	}
+}
+
+func TestClient_ShellTransportFailure(t *testing.T) {
+	client := fakeServer(t, func(conn net.Conn) {
+		readRequest(t, conn)
+		writeFail(conn, "device 'emulator-5554' not found")
+	})
+
+	_, err := client.Shell(context.Background(), "emulator-5554", "getprop")
+	if !IsDeviceNotFound(err) {
+		t.Errorf("expected a device not found error, got: %v", err)
+	}
+}
+
+func TestClient_HangingServer(t *testing.T) {
+	release := make(chan struct{})
+	t.Cleanup(func() {
+		close(release)
+	})
+	// The server accepts the request, but never answers it, like a wedged adb server.
+	client := fakeServer(t, func(conn net.Conn) {
+		readRequest(t, conn)
+		<-release
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		_, err := client.Shell(ctx, "emulator-5554", "getprop")
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected a deadline exceeded error, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("request returned after %s", elapsed)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+
+		start := time.Now()
+		_, err := client.Version(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected a cancelled error, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("request returned after %s", elapsed)
+		}
+	})
+}
+
+func TestClient_ServerNotRunning(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &Client{addr: addr}
+	if _, err := client.Devices(context.Background()); !errors.Is(err, ErrServerNotRunning) {
+		t.Errorf("expected a server not running error, got: %v", err)
+	}
+}
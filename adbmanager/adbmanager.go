@@ -0,0 +1,158 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/pathutil"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+type Model struct {
+	binPth     string
+	cmdFactory CommandFactory
+	client     *adbclient.Client
+	serverPort int
+	version    Version
+	logger     log.Logger
+}
+
+// New checks that the adb binary can be executed and it is at least minVersion (a platform-tools version,
+// e.g. 30.0.0, empty to accept any version). See ResolveBinary for locating the binary.
+func New(binPth string, cmdFactory CommandFactory, logger log.Logger, minVersion string) (*Model, error) {
+	if exist, err := pathutil.IsPathExists(binPth); err != nil {
+		return nil, fmt.Errorf("failed to check if adb exist, error: %s", err)
+	} else if !exist {
+		return nil, fmt.Errorf("adb not exist at: %s", binPth)
+	}
+
+	model := &Model{
+		binPth:     binPth,
+		cmdFactory: cmdFactory,
+		logger:     logger,
+	}
+
+	version, err := model.checkVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("Using adb %s at %s", version, binPth)
+	model.version = version
+
+	return model, nil
+}
+
+// SetClient makes the boot checks talk to the adb server through the native client, the adb binary
+// is only used if the client can't reach the server.
+func (model *Model) SetClient(client *adbclient.Client) {
+	model.client = client
+}
+
+// SetServerPort makes the adb commands talk to the adb server on the given port instead of the default one,
+// through the ANDROID_ADB_SERVER_PORT environment variable.
+func (model *Model) SetServerPort(port int) {
+	model.serverPort = port
+}
+
+// StartServerCmd returns a command that starts the ADB server if it is not running yet.
+func (model Model) StartServerCmd(ctx context.Context, commandOptions *command.Opts) command.Command {
+	return model.command(ctx, []string{"start-server"}, commandOptions)
+}
+
+// DevicesCmd returns a command that lists the devices with their details (`adb devices -l`).
+func (model Model) DevicesCmd(ctx context.Context, commandOptions *command.Opts) command.Command {
+	return model.command(ctx, []string{"devices", "-l"}, commandOptions)
+}
+
+// LogcatCmd returns a command that waits for the device to appear and then streams its logcat until it is stopped.
+// If since is set (a threadtime timestamp, e.g. 10-17 12:00:00.000), the lines logged before it are not printed.
+func (model Model) LogcatCmd(ctx context.Context, serial, since string, commandOptions *command.Opts) command.Command {
+	args := []string{"-s", serial, "wait-for-device", "logcat", "-v", "threadtime"}
+	if since != "" {
+		// adb escapes the logcat arguments for the device shell.
+		args = append(args, "-T", since)
+	}
+	return model.command(ctx, args, commandOptions)
+}
+
+// DeviceState returns the state of the device as seen by the adb server (e.g. device, offline, unauthorized).
+func (model Model) DeviceState(ctx context.Context, serial string) (string, error) {
+	if model.client != nil {
+		state, err := model.client.State(ctx, serial)
+		if err == nil || !errors.Is(err, adbclient.ErrServerNotRunning) {
+			return state, err
+		}
+	}
+
+	cmd := model.command(ctx, []string{"-s", serial, "get-state"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, out)
+	}
+	return out, nil
+}
+
+// GetProp returns the value of the given system property of the device.
+func (model Model) GetProp(ctx context.Context, serial, name string) (string, error) {
+	cmd := model.ShellCmd(ctx, serial, nil, "getprop", name)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, out)
+	}
+	return out, nil
+}
+
+// ShellCmd returns a command that executes the provided command(s) on the device shell.
+func (model Model) ShellCmd(ctx context.Context, serial string, commandOptions *command.Opts, commands ...string) command.Command {
+	args := append([]string{"-s", serial, "shell"}, commands...)
+	return model.command(ctx, args, commandOptions)
+}
+
+// shellQuote quotes the argument for the device shell, `adb shell` joins its arguments into a single command line.
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// ExecOutCmd returns a command that executes the provided command(s) on the device without a pty,
+// so binary output (e.g. `screencap -p`) is passed through unaltered.
+func (model Model) ExecOutCmd(ctx context.Context, serial string, commandOptions *command.Opts, commands ...string) command.Command {
+	args := append([]string{"-s", serial, "exec-out"}, commands...)
+	return model.command(ctx, args, commandOptions)
+}
+
+// WaitForDeviceThenShellCmd returns a command that first waits for a device to come online, then executes the provided
+// command(s) on the device shell
+func (model Model) WaitForDeviceThenShellCmd(ctx context.Context, serial string, commandOptions *command.Opts, commands ...string) command.Command {
+	var args []string
+	if serial != "" {
+		args = append(args, "-s", serial)
+	}
+	args = append(args, "wait-for-device", "shell")
+	args = append(args, commands...)
+
+	return model.command(ctx, args, commandOptions)
+}
+
+// KillServerCmd returns a command that kills the ADB server if it is running.
+// The next ADB command will automatically start the server.
+func (model Model) KillServerCmd(ctx context.Context, commandOptions *command.Opts) command.Command {
+	return model.command(ctx, []string{"kill-server"}, commandOptions)
+}
+
+// command creates an adb command, which talks to the server port set by SetServerPort.
+func (model Model) command(ctx context.Context, args []string, commandOptions *command.Opts) command.Command {
+	if model.serverPort != 0 {
+		var opts command.Opts
+		if commandOptions != nil {
+			opts = *commandOptions
+		}
+		opts.Env = append(append([]string{}, opts.Env...), "ANDROID_ADB_SERVER_PORT="+strconv.Itoa(model.serverPort))
+		commandOptions = &opts
+	}
+	return model.cmdFactory.Create(ctx, model.binPth, args, commandOptions)
+}
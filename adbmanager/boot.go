@@ -0,0 +1,137 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+// deviceOnlinePending is reported as pending while the adb server doesn't see the device as online.
+const deviceOnlinePending = "device_online"
+
+// errDeviceNotReady signals that the adb server doesn't know the device or it is not online yet.
+var errDeviceNotReady = errors.New("device is not ready")
+
+type WaitForBootCompleteResult struct {
+	// Booted is true if the device is online AND every readiness probe passed. A false value doesn't
+	// necessarily mean an error, a retry might resolve things.
+	Booted bool
+	// Pending lists the names of the readiness probes that did not pass yet.
+	Pending []string
+	// Error signals a problem with the adb connection, it is retried after restarting the adb server.
+	Error error
+	// Failure signals a problem of the device that waiting won't resolve.
+	Failure error
+}
+
+// checkBootComplete runs the readiness probes one after the other. Every adb process it starts is bound to ctx,
+// so nothing is left running once the context is done.
+func (model *Model) checkBootComplete(ctx context.Context, serial string, probes []ReadinessProbe) WaitForBootCompleteResult {
+	var pending []string
+	for _, probe := range probes {
+		out, err := model.runProbe(ctx, serial, probe)
+		if errors.Is(err, errDeviceNotReady) {
+			return WaitForBootCompleteResult{Pending: []string{deviceOnlinePending}}
+		} else if err != nil {
+			return WaitForBootCompleteResult{Error: err}
+		}
+
+		if !probe.IsReady(out) {
+			pending = append(pending, probe.Name)
+		} else if probe.Verify != nil {
+			if err := probe.Verify(out); err != nil {
+				return WaitForBootCompleteResult{Failure: err}
+			}
+		}
+	}
+
+	return WaitForBootCompleteResult{Booted: len(pending) == 0, Pending: pending}
+}
+
+// bootStageProperties change as the boot progresses, they are used to detect a stalled boot.
+var bootStageProperties = []string{
+	"init.svc.zygote",
+	"init.svc.surfaceflinger",
+	"init.svc.bootanim",
+	"vold.post_fs_data_done",
+	"service.bootanim.exit",
+	"dev.bootcomplete",
+	"sys.boot_completed",
+}
+
+// bootStage returns the values of the boot stage properties, it is empty if the device is not online.
+func (model *Model) bootStage(ctx context.Context, serial string) map[string]string {
+	stage := map[string]string{}
+	out, err := model.shellOutput(ctx, serial, "getprop")
+	if err != nil {
+		return stage
+	}
+
+	// Example line: [init.svc.bootanim]: [running]
+	for _, line := range strings.Split(out, "\n") {
+		for _, property := range bootStageProperties {
+			prefix := "[" + property + "]: ["
+			if strings.HasPrefix(line, prefix) {
+				stage[property] = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(line), prefix), "]")
+			}
+		}
+	}
+	return stage
+}
+
+func formatBootStage(stage map[string]string) string {
+	var values []string
+	for _, property := range bootStageProperties {
+		if value, ok := stage[property]; ok {
+			values = append(values, property+"="+value)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+func (model *Model) runProbe(ctx context.Context, serial string, probe ReadinessProbe) (string, error) {
+	// The probe's exit code is swallowed on purpose: a failing device-side command (e.g. `pm` while the
+	// package manager is starting) means "not ready yet", while a failing adb invocation is still reported.
+	return model.shellOutput(ctx, serial, probe.Command+" 2>&1; true")
+}
+
+// shellOutput runs the command through the native adb client if it is set, falling back to the adb binary
+// if the client can't reach the server.
+func (model *Model) shellOutput(ctx context.Context, serial string, shellCommand string) (string, error) {
+	if model.client != nil {
+		out, err := model.client.Shell(ctx, serial, shellCommand)
+		switch {
+		case err == nil:
+			return out, nil
+		case adbclient.IsDeviceNotFound(err) || adbclient.IsDeviceOffline(err):
+			model.logger.Debugf("Device (%s) is not ready: %s", serial, err)
+			return "", errDeviceNotReady
+		case !errors.Is(err, adbclient.ErrServerNotRunning):
+			// Other errors of a running server are recovered from by the boot check, like the errors of the binary.
+			return "", err
+		}
+		model.logger.Warnf("Native adb client can't reach the server, falling back to the adb binary: %s", err)
+	}
+
+	cmd := model.WaitForDeviceThenShellCmd(ctx, serial, nil, shellCommand)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		model.logger.Debugf("Device (%s): %s failed: %s", serial, cmd.PrintableCommandArgs(), out)
+		// The output tells the cause of the failure, e.g. an adb server version conflict.
+		return out, fmt.Errorf("%w: %s", err, out)
+	}
+	return out, nil
+}
+
+func containsLine(out, expected string) bool {
+	lines := strings.Split(out, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == expected {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package adbmanager
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+// failingServer rejects every request with the given message.
+func failingServer(t *testing.T, message string) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			lengthHex := make([]byte, 4)
+			if _, err := io.ReadFull(conn, lengthHex); err == nil {
+				var length int
+				if _, err := fmt.Sscanf(string(lengthHex), "%04x", &length); err == nil {
+					_, _ = io.ReadFull(conn, make([]byte, length))
+				}
+				_, _ = fmt.Fprintf(conn, "FAIL%04x%s", len(message), message)
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestShellOutput_FallsBackOnlyIfServerIsNotRunning(t *testing.T) {
+	binPth := filepath.Join(t.TempDir(), "adb")
+	if err := os.WriteFile(binPth, []byte("#!/usr/bin/env bash\necho binary\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	stoppedServer, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stoppedPort := stoppedServer.Addr().(*net.TCPAddr).Port
+	if err := stoppedServer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		port    int
+		want    string
+		wantErr bool
+	}{
+		{name: "server not running", port: stoppedPort, want: "binary"},
+		{name: "server error", port: failingServer(t, "protocol fault (no status)"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := Model{
+				binPth:     binPth,
+				cmdFactory: NewCommandFactory(env.NewRepository()),
+				client:     adbclient.New(tt.port),
+				logger:     log.NewLogger(),
+			}
+
+			out, err := model.shellOutput(context.Background(), "emulator-5554", "getprop sys.boot_completed")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("shellOutput() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if out != tt.want {
+				t.Errorf("shellOutput() = %q, want %q", out, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// waitDelay is how long a cancelled command may keep its output pipes open after being killed.
+const waitDelay = 2 * time.Second
+
+// CommandFactory creates commands bound to a context. When the context is done, the command's
+// process and every process it started in its process group are killed.
+type CommandFactory interface {
+	Create(ctx context.Context, name string, args []string, opts *command.Opts) command.Command
+}
+
+type commandFactory struct {
+	envRepository env.Repository
+}
+
+// NewCommandFactory ...
+func NewCommandFactory(envRepository env.Repository) CommandFactory {
+	return commandFactory{envRepository: envRepository}
+}
+
+// Create ...
+func (f commandFactory) Create(ctx context.Context, name string, args []string, opts *command.Opts) command.Command {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Run the command in its own process group, so that children of the command
+	// (e.g. a wrapper script's subprocesses) are killed together with it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+
+	if opts != nil {
+		cmd.Stdout = opts.Stdout
+		cmd.Stderr = opts.Stderr
+		cmd.Stdin = opts.Stdin
+		cmd.Env = append(f.envRepository.List(), opts.Env...)
+		cmd.Dir = opts.Dir
+	}
+
+	return &contextCommand{ctx: ctx, cmd: cmd}
+}
+
+type contextCommand struct {
+	ctx context.Context
+	cmd *exec.Cmd
+}
+
+// PrintableCommandArgs ...
+func (c *contextCommand) PrintableCommandArgs() string {
+	var args []string
+	for idx, arg := range c.cmd.Args {
+		if idx == 0 {
+			args = append(args, arg)
+		} else {
+			args = append(args, fmt.Sprintf("\"%s\"", arg))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// Run ...
+func (c *contextCommand) Run() error {
+	return c.wrapError(c.cmd.Run())
+}
+
+// RunAndReturnExitCode ...
+func (c *contextCommand) RunAndReturnExitCode() (int, error) {
+	err := c.wrapError(c.cmd.Run())
+	return c.cmd.ProcessState.ExitCode(), err
+}
+
+// RunAndReturnTrimmedOutput ...
+func (c *contextCommand) RunAndReturnTrimmedOutput() (string, error) {
+	out, err := c.cmd.Output()
+	return strings.TrimSpace(string(out)), c.wrapError(err)
+}
+
+// RunAndReturnTrimmedCombinedOutput ...
+func (c *contextCommand) RunAndReturnTrimmedCombinedOutput() (string, error) {
+	out, err := c.cmd.CombinedOutput()
+	return strings.TrimSpace(string(out)), c.wrapError(err)
+}
+
+// Start ...
+func (c *contextCommand) Start() error {
+	return c.wrapError(c.cmd.Start())
+}
+
+// Wait ...
+func (c *contextCommand) Wait() error {
+	return c.wrapError(c.cmd.Wait())
+}
+
+func (c *contextCommand) wrapError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if ctxErr := c.ctx.Err(); ctxErr != nil {
+		return fmt.Errorf("command cancelled (%s): %w", c.PrintableCommandArgs(), ctxErr)
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return command.NewExitStatusError(c.PrintableCommandArgs(), exitErr, nil)
+	}
+
+	return fmt.Errorf("executing command failed (%s): %w", c.PrintableCommandArgs(), err)
+}
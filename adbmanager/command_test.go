@@ -0,0 +1,111 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+)
+
+// hangingAdb starts a child process and both of them hang, like a wedged `adb wait-for-device`.
+const hangingAdb = `#!/usr/bin/env bash
+sleep 60 &
+echo "$$ $!" > "$PIDS_FILE"
+sleep 60
+`
+
+func TestCommandFactory_KillsProcessGroupOnDeadline(t *testing.T) {
+	dir := t.TempDir()
+	adbPth := filepath.Join(dir, "adb")
+	pidsPth := filepath.Join(dir, "pids")
+	if err := os.WriteFile(adbPth, []byte(hangingAdb), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PIDS_FILE", pidsPth)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	cmd := NewCommandFactory(env.NewRepository()).Create(ctx, adbPth, []string{"wait-for-device"}, nil)
+	_, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a deadline exceeded error, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second+waitDelay+time.Second {
+		t.Fatalf("command returned %s after the deadline", elapsed-time.Second)
+	}
+
+	content, err := os.ReadFile(pidsPth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pids []int
+	for _, field := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids = append(pids, pid)
+	}
+	if len(pids) != 2 {
+		t.Fatalf("expected the pid of the script and its child, got: %s", content)
+	}
+
+	// The script is the leader of its own process group.
+	pgid := pids[0]
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		alive := livePIDs(t, pgid)
+		if len(alive) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("processes of the process group (%d) are still running: %v", pgid, alive)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, pid := range pids {
+		if isLive(pid) {
+			t.Fatalf("process (%d) is still running", pid)
+		}
+	}
+}
+
+// livePIDs returns the processes of the process group that are not zombies.
+func livePIDs(t *testing.T, pgid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var pids []int
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if processGroup, err := syscall.Getpgid(pid); err == nil && processGroup == pgid && isLive(pid) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// isLive tells if the process exists and it is not a zombie waiting to be reaped.
+func isLive(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	// Example: 1234 (sleep) S 1 ...
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
@@ -0,0 +1,68 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+// IsNetworkSerial tells if the serial is a host:port target, which has to be connected with `adb connect`.
+func IsNetworkSerial(serial string) bool {
+	host, port, err := net.SplitHostPort(serial)
+	if err != nil || host == "" {
+		return false
+	}
+	_, err = strconv.Atoi(port)
+	return err == nil
+}
+
+// Connect connects the adb server to the network device (host:port). Connecting an already connected device succeeds.
+func (model Model) Connect(ctx context.Context, target string) error {
+	out, err := model.hostCommand(ctx, "connect", target)
+	if err != nil {
+		return err
+	}
+
+	// `adb connect` exits with 0 even if it failed, only the output tells the result.
+	// Example outputs: "connected to 10.0.0.2:5555", "already connected to 10.0.0.2:5555",
+	// "failed to connect to '10.0.0.2:5555': Connection refused", "cannot connect to 10.0.0.2:5555: Connection refused"
+	if !strings.HasPrefix(out, "connected to") && !strings.HasPrefix(out, "already connected to") {
+		return fmt.Errorf("failed to connect to %s: %s", target, out)
+	}
+	return nil
+}
+
+// Disconnect disconnects the adb server from the network device (host:port).
+func (model Model) Disconnect(ctx context.Context, target string) error {
+	_, err := model.hostCommand(ctx, "disconnect", target)
+	return err
+}
+
+// hostCommand runs connect or disconnect through the native client if it is set, falling back to the adb binary
+// if the client can't reach the server.
+func (model Model) hostCommand(ctx context.Context, name, target string) (string, error) {
+	if model.client != nil {
+		var out string
+		var err error
+		if name == "connect" {
+			out, err = model.client.Connect(ctx, target)
+		} else {
+			out, err = model.client.Disconnect(ctx, target)
+		}
+		if err == nil || !errors.Is(err, adbclient.ErrServerNotRunning) {
+			return out, err
+		}
+	}
+
+	cmd := model.command(ctx, []string{name, target}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, out)
+	}
+	return out, nil
+}
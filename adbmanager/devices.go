@@ -0,0 +1,116 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+// Device states reported by `adb devices`.
+const (
+	StateDevice       = "device"
+	StateOffline      = "offline"
+	StateUnauthorized = "unauthorized"
+	StateRecovery     = "recovery"
+	// StateMissing is used for devices that are not listed at all.
+	StateMissing = "missing"
+)
+
+// Device is an entry of the `adb devices -l` list.
+type Device struct {
+	Serial      string
+	State       string
+	Product     string
+	Model       string
+	Device      string
+	TransportID string
+}
+
+// IsEmulator tells if the device is a local emulator.
+func (d Device) IsEmulator() bool {
+	return strings.HasPrefix(d.Serial, "emulator-")
+}
+
+// ParseDevices parses the output of `adb devices -l`.
+func ParseDevices(out string) []Device {
+	// Example output:
+	// List of devices attached
+	// emulator-5554          device product:sdk_gphone64_x86_64 model:sdk_gphone64_x86_64 device:emu64x transport_id:1
+	// emulator-5556          offline transport_id:2
+	var devices []Device
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(line, "List of devices") || strings.HasPrefix(line, "*") {
+			continue
+		}
+
+		device := Device{Serial: fields[0], State: fields[1]}
+		for _, field := range fields[2:] {
+			key, value, ok := strings.Cut(field, ":")
+			if !ok {
+				continue
+			}
+
+			switch key {
+			case "product":
+				device.Product = value
+			case "model":
+				device.Model = value
+			case "device":
+				device.Device = value
+			case "transport_id":
+				device.TransportID = value
+			}
+		}
+		devices = append(devices, device)
+	}
+	return devices
+}
+
+// Devices returns the devices known by the adb server, through the native client if it is set.
+func (model Model) Devices(ctx context.Context) ([]Device, error) {
+	if model.client != nil {
+		out, err := model.client.Devices(ctx)
+		if err == nil {
+			return ParseDevices(out), nil
+		} else if !errors.Is(err, adbclient.ErrServerNotRunning) {
+			return nil, err
+		}
+	}
+
+	cmd := model.DevicesCmd(ctx, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", err, out)
+	}
+	return ParseDevices(out), nil
+}
+
+// EmulatorSerials returns the serials of the emulators listed by `adb devices`, regardless of their state.
+func (model Model) EmulatorSerials(ctx context.Context) ([]string, error) {
+	devices, err := model.Devices(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var serials []string
+	for _, device := range devices {
+		if device.IsEmulator() {
+			serials = append(serials, device.Serial)
+		}
+	}
+	return serials, nil
+}
+
+// deviceState returns the state of the device in the list, StateMissing if it is not listed.
+func deviceState(devices []Device, serial string) string {
+	for _, device := range devices {
+		if device.Serial == serial {
+			return device.State
+		}
+	}
+	return StateMissing
+}
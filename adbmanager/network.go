@@ -0,0 +1,58 @@
+package adbmanager
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const networkReachable = "reachable"
+
+// hostnamePattern matches DNS hostnames (RFC 1123), e.g. example.com or my-server.
+var hostnamePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)(\.[A-Za-z0-9]([A-Za-z0-9-]{0,61}[A-Za-z0-9])?)*$`)
+
+// networkProbe passes once the connectivity service reports an active default network.
+var networkProbe = ReadinessProbe{
+	Name:    ProbeNetwork,
+	Command: "dumpsys connectivity",
+	IsReady: func(out string) bool {
+		// Example line: "Active default network: 100", or "Active default network: none" while the network is down.
+		for _, line := range strings.Split(out, "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), "Active default network:"); ok {
+				value = strings.TrimSpace(value)
+				return value != "" && value != "none"
+			}
+		}
+		return false
+	},
+}
+
+// NetworkTargetProbe returns a probe that passes once the target is reachable from the device: a host is pinged,
+// a host:port is connected to with nc (e.g. 10.0.2.2:8080 for a server on the host machine).
+func NetworkTargetProbe(target string) (ReadinessProbe, error) {
+	host, port := target, ""
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		host, port = h, p
+	} else if strings.Contains(target, ":") && net.ParseIP(target) == nil {
+		return ReadinessProbe{}, fmt.Errorf("invalid network target (%s), use host or host:port", target)
+	}
+
+	if net.ParseIP(host) == nil && !hostnamePattern.MatchString(host) {
+		return ReadinessProbe{}, fmt.Errorf("invalid host in network target (%s)", target)
+	}
+	check := "ping -c 1 -W 3 " + shellQuote(host)
+	if port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return ReadinessProbe{}, fmt.Errorf("invalid port in network target (%s), it must be between 1 and 65535", target)
+		}
+		check = fmt.Sprintf("nc -w 3 -q 1 %s %s </dev/null", shellQuote(host), shellQuote(port))
+	}
+
+	return ReadinessProbe{
+		Name:    "network_target:" + target,
+		Command: fmt.Sprintf("if %s >/dev/null 2>&1; then echo %s; fi", check, networkReachable),
+		IsReady: func(out string) bool { return containsLine(out, networkReachable) },
+	}, nil
+}
@@ -0,0 +1,36 @@
+package adbmanager
+
+import "testing"
+
+func TestNetworkTargetProbe(t *testing.T) {
+	tests := []struct {
+		target      string
+		wantCommand string
+		wantErr     bool
+	}{
+		{target: "10.0.2.2", wantCommand: "if ping -c 1 -W 3 '10.0.2.2' >/dev/null 2>&1; then echo reachable; fi"},
+		{target: "example.com", wantCommand: "if ping -c 1 -W 3 'example.com' >/dev/null 2>&1; then echo reachable; fi"},
+		{target: "10.0.2.2:8080", wantCommand: "if nc -w 3 -q 1 '10.0.2.2' '8080' </dev/null >/dev/null 2>&1; then echo reachable; fi"},
+		{target: "[::1]:8080", wantCommand: "if nc -w 3 -q 1 '::1' '8080' </dev/null >/dev/null 2>&1; then echo reachable; fi"},
+		{target: "my-server:443", wantCommand: "if nc -w 3 -q 1 'my-server' '443' </dev/null >/dev/null 2>&1; then echo reachable; fi"},
+		{target: "10.0.2.2:0", wantErr: true},
+		{target: "10.0.2.2:65536", wantErr: true},
+		{target: "10.0.2.2:http", wantErr: true},
+		{target: "example.com;reboot", wantErr: true},
+		{target: "$(reboot):80", wantErr: true},
+		{target: "-c:80", wantErr: true},
+		{target: "a:b:c", wantErr: true},
+		{target: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			probe, err := NetworkTargetProbe(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NetworkTargetProbe(%q) error = %v, wantErr %t", tt.target, err, tt.wantErr)
+			}
+			if probe.Command != tt.wantCommand {
+				t.Errorf("command = %q, want %q", probe.Command, tt.wantCommand)
+			}
+		})
+	}
+}
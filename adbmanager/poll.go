@@ -0,0 +1,54 @@
+package adbmanager
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const defaultPollInterval = 5 * time.Second
+
+// PollStrategy tells how long to wait before the next boot check.
+type PollStrategy interface {
+	// Delay returns the delay after the given (1 based) failed attempt.
+	Delay(attempt int) time.Duration
+}
+
+// FixedPoll waits the same interval between every attempt.
+type FixedPoll struct {
+	Interval time.Duration
+}
+
+// Delay ...
+func (p FixedPoll) Delay(int) time.Duration {
+	return p.Interval
+}
+
+// ExponentialBackoff doubles the delay after each attempt, up to Max.
+type ExponentialBackoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay ...
+func (p ExponentialBackoff) Delay(attempt int) time.Duration {
+	delay := float64(p.Initial) * math.Pow(2, float64(attempt-1))
+	if delay > float64(p.Max) {
+		return p.Max
+	}
+	return time.Duration(delay)
+}
+
+// Jitter randomizes the delay of the wrapped strategy by up to ±Fraction of it, so that parallel waits
+// don't hit the adb server at the same time.
+type Jitter struct {
+	Strategy PollStrategy
+	Fraction float64
+}
+
+// Delay ...
+func (p Jitter) Delay(attempt int) time.Duration {
+	delay := p.Strategy.Delay(attempt)
+	jitter := (rand.Float64()*2 - 1) * p.Fraction * float64(delay)
+	return time.Duration(float64(delay) + jitter)
+}
@@ -0,0 +1,148 @@
+package adbmanager
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ProbeBootCompleted   = "boot_completed"
+	ProbeDevBootComplete = "dev_bootcomplete"
+	ProbeBootAnimStopped = "bootanim_stopped"
+	ProbePackageManager  = "package_manager"
+	ProbePackageService  = "package_service"
+	ProbeActivityService = "activity_service"
+	ProbeSettingsService = "settings_service"
+	ProbeStorage         = "storage"
+	ProbeNetwork         = "network"
+)
+
+// ReadinessProbe is a device shell check that has to pass before the device is reported as ready.
+type ReadinessProbe struct {
+	// Name identifies the probe in the step inputs and in the logs.
+	Name string
+	// Command is executed in the device shell.
+	Command string
+	// IsReady tells if the output of Command means the probe passed.
+	IsReady func(out string) bool
+	// Verify is optional, it is called once the probe passed. An error fails the wait, as waiting won't fix it.
+	Verify func(out string) error
+}
+
+var readinessProbes = []ReadinessProbe{
+	{
+		Name:    ProbeBootCompleted,
+		Command: "getprop sys.boot_completed",
+		IsReady: func(out string) bool { return containsLine(out, "1") },
+	},
+	{
+		Name:    ProbeDevBootComplete,
+		Command: "getprop dev.bootcomplete",
+		IsReady: func(out string) bool { return containsLine(out, "1") },
+	},
+	{
+		Name:    ProbeBootAnimStopped,
+		Command: "getprop init.svc.bootanim",
+		IsReady: func(out string) bool { return containsLine(out, "stopped") },
+	},
+	{
+		Name:    ProbePackageManager,
+		Command: "pm path android",
+		IsReady: func(out string) bool { return strings.HasPrefix(out, "package:") },
+	},
+	serviceProbe(ProbePackageService, "package"),
+	serviceProbe(ProbeActivityService, "activity"),
+	serviceProbe(ProbeSettingsService, "settings"),
+}
+
+// optionalReadinessProbes are available by name, but they are not part of the defaults.
+var optionalReadinessProbes = []ReadinessProbe{
+	StorageProbe(0),
+	networkProbe,
+}
+
+func serviceProbe(name, service string) ReadinessProbe {
+	return ReadinessProbe{
+		Name:    name,
+		Command: "service check " + service,
+		// Example output: "Service package: found"
+		IsReady: func(out string) bool { return strings.HasSuffix(out, ": found") },
+	}
+}
+
+var (
+	// serviceNamePattern matches the names registered with the service manager,
+	// e.g. package, media.camera or android.hardware.power.IPower/default.
+	serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-/]*$`)
+	// packageNamePattern matches Java package names, the segments have to start with a letter.
+	packageNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$`)
+)
+
+// ServiceProbe returns a probe that passes once the system service is registered (e.g. input).
+// The service name is validated, as it is run in the device shell.
+func ServiceProbe(service string) (ReadinessProbe, error) {
+	if !serviceNamePattern.MatchString(service) {
+		return ReadinessProbe{}, fmt.Errorf("invalid service name: %s", service)
+	}
+	return serviceProbe("service:"+service, service), nil
+}
+
+// PackageProbe returns a probe that passes once the package is installed (e.g. com.google.android.gms).
+// The package name is validated, as it is run in the device shell.
+func PackageProbe(packageName string) (ReadinessProbe, error) {
+	if !packageNamePattern.MatchString(packageName) {
+		return ReadinessProbe{}, fmt.Errorf("invalid package name: %s", packageName)
+	}
+	return ReadinessProbe{
+		Name:    "package:" + packageName,
+		Command: "pm path " + packageName,
+		// Example output: "package:/data/app/com.google.android.gms-1/base.apk", nothing if it is not installed.
+		IsReady: func(out string) bool { return strings.HasPrefix(out, "package:") },
+	}, nil
+}
+
+// DefaultReadinessProbes returns every known readiness probe, except for the optional ones.
+func DefaultReadinessProbes() []ReadinessProbe {
+	return append([]ReadinessProbe{}, readinessProbes...)
+}
+
+// ReadinessProbesByName returns the readiness probes with the given names, in the given order.
+func ReadinessProbesByName(names []string) ([]ReadinessProbe, error) {
+	var probes []ReadinessProbe
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		probe, ok := findReadinessProbe(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown readiness probe: %s, available probes: %s", name, strings.Join(readinessProbeNames(), ", "))
+		}
+		probes = append(probes, probe)
+	}
+
+	if len(probes) == 0 {
+		return nil, fmt.Errorf("no readiness probe specified, available probes: %s", strings.Join(readinessProbeNames(), ", "))
+	}
+
+	return probes, nil
+}
+
+func findReadinessProbe(name string) (ReadinessProbe, bool) {
+	for _, probe := range append(DefaultReadinessProbes(), optionalReadinessProbes...) {
+		if probe.Name == name {
+			return probe, true
+		}
+	}
+	return ReadinessProbe{}, false
+}
+
+func readinessProbeNames() []string {
+	var names []string
+	for _, probe := range append(DefaultReadinessProbes(), optionalReadinessProbes...) {
+		names = append(names, probe.Name)
+	}
+	return names
+}
@@ -0,0 +1,48 @@
+package adbmanager
+
+import "testing"
+
+func TestServiceProbe(t *testing.T) {
+	tests := []struct {
+		service string
+		wantErr bool
+	}{
+		{service: "input"},
+		{service: "media.camera"},
+		{service: "android.hardware.power.IPower/default"},
+		{service: "wifi; reboot", wantErr: true},
+		{service: "$(reboot)", wantErr: true},
+		{service: "-l", wantErr: true},
+		{service: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			if _, err := ServiceProbe(tt.service); (err != nil) != tt.wantErr {
+				t.Errorf("ServiceProbe(%q) error = %v, wantErr %t", tt.service, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPackageProbe(t *testing.T) {
+	tests := []struct {
+		packageName string
+		wantErr     bool
+	}{
+		{packageName: "android"},
+		{packageName: "com.google.android.gms"},
+		{packageName: "com.example.my_app2"},
+		{packageName: "com.example.2app", wantErr: true},
+		{packageName: "com.example.", wantErr: true},
+		{packageName: "com.example && reboot", wantErr: true},
+		{packageName: "`reboot`", wantErr: true},
+		{packageName: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.packageName, func(t *testing.T) {
+			if _, err := PackageProbe(tt.packageName); (err != nil) != tt.wantErr {
+				t.Errorf("PackageProbe(%q) error = %v, wantErr %t", tt.packageName, err, tt.wantErr)
+			}
+		})
+	}
+}
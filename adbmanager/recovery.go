@@ -0,0 +1,128 @@
+package adbmanager
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// recoveryTimeout limits a recovery command, a wedged adb server might not answer a reconnect either.
+const recoveryTimeout = 30 * time.Second
+
+// RecoveryLevel is a step of the escalating recovery from adb errors.
+type RecoveryLevel int
+
+const (
+	RecoveryNone RecoveryLevel = iota
+	// RecoveryReconnect reconnects the transport of the device (`adb -s <serial> reconnect`).
+	RecoveryReconnect
+	// RecoveryReconnectOffline reconnects every offline device (`adb reconnect offline`).
+	RecoveryReconnectOffline
+	// RecoveryServerRestart kills the adb server, which disconnects every device of the host.
+	RecoveryServerRestart
+)
+
+func (l RecoveryLevel) String() string {
+	switch l {
+	case RecoveryReconnect:
+		return "device reconnect"
+	case RecoveryReconnectOffline:
+		return "offline devices reconnect"
+	case RecoveryServerRestart:
+		return "adb server restart"
+	default:
+		return "none"
+	}
+}
+
+// Example: adb server version (39) doesn't match this client (41); killing...
+var serverVersionConflictRegexp = regexp.MustCompile(`adb server version \((\d+)\) doesn't match this client \((\d+)\)`)
+
+// serverRecovery escalates the recovery steps on consecutive adb errors of a device, so a single failed check
+// doesn't restart the adb server used by every other emulator and tool on the host.
+type serverRecovery struct {
+	model            Model
+	serial           string
+	restartThreshold int
+
+	failures  int
+	lastLevel RecoveryLevel
+}
+
+func newServerRecovery(model Model, serial string, restartThreshold int) *serverRecovery {
+	return &serverRecovery{model: model, serial: serial, restartThreshold: restartThreshold}
+}
+
+// recover runs the recovery step for the next consecutive failure and returns its level.
+func (r *serverRecovery) recover(ctx context.Context, checkErr error) (RecoveryLevel, error) {
+	r.failures++
+
+	level := RecoveryReconnectOffline
+	if serverVersion, clientVersion, ok := r.versionConflict(ctx, checkErr); ok {
+		r.model.logger.Warnf("A foreign adb server (version %d) runs on the adb server port, this adb is version %d.", serverVersion, clientVersion)
+		r.model.logger.Warnf("Another tool uses a different platform-tools, consider using a private adb server port.")
+		level = RecoveryServerRestart
+	} else if r.failures >= r.restartThreshold {
+		level = RecoveryServerRestart
+	} else if r.failures == 1 {
+		level = RecoveryReconnect
+	}
+
+	r.model.logger.Warnf("Recovering from %d consecutive failures with %s...", r.failures, level)
+	var args []string
+	switch level {
+	case RecoveryReconnect:
+		args = []string{"-s", r.serial, "reconnect"}
+	case RecoveryReconnectOffline:
+		args = []string{"reconnect", "offline"}
+	case RecoveryServerRestart:
+		args = []string{"kill-server"}
+		r.failures = 0
+	}
+	r.lastLevel = level
+
+	ctx, cancel := context.WithTimeout(ctx, recoveryTimeout)
+	defer cancel()
+
+	cmd := r.model.command(ctx, args, nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return level, fmt.Errorf("%s failed: %s: %s", level, err, out)
+	}
+	return level, nil
+}
+
+// versionConflict tells if the adb server is of a different version than the adb binary. The adb binary reports
+// the conflict in its output, the native client has to ask the server for its version.
+func (r *serverRecovery) versionConflict(ctx context.Context, checkErr error) (serverVersion, clientVersion int, conflict bool) {
+	if matches := serverVersionConflictRegexp.FindStringSubmatch(checkErr.Error()); matches != nil {
+		serverVersion, _ = strconv.Atoi(matches[1])
+		clientVersion, _ = strconv.Atoi(matches[2])
+		return serverVersion, clientVersion, true
+	}
+
+	clientVersion, ok := r.model.version.Protocol()
+	if r.model.client == nil || !ok {
+		return 0, 0, false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, stateQueryTimeout)
+	defer cancel()
+
+	serverVersion, err := r.model.client.Version(ctx)
+	if err != nil {
+		r.model.logger.Debugf("Failed to query the adb server version: %s", err)
+		return 0, 0, false
+	}
+	return serverVersion, clientVersion, serverVersion != clientVersion
+}
+
+// succeeded resets the escalation after a boot check that reached the device, logging the level that fixed things.
+func (r *serverRecovery) succeeded() {
+	if r.lastLevel != RecoveryNone {
+		r.model.logger.Donef("Device (%s) is reachable again after %s", r.serial, r.lastLevel)
+	}
+	r.failures = 0
+	r.lastLevel = RecoveryNone
+}
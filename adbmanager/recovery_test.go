@@ -0,0 +1,120 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+)
+
+// versionServer answers every host:version request of the native client with the given protocol version.
+func versionServer(t *testing.T, version int) *adbclient.Client {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			request := make([]byte, len("000chost:version"))
+			if _, err := io.ReadFull(conn, request); err == nil && string(request) == "000chost:version" {
+				reply := fmt.Sprintf("%04x", version)
+				_, _ = fmt.Fprintf(conn, "OKAY%04x%s", len(reply), reply)
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return adbclient.New(ln.Addr().(*net.TCPAddr).Port)
+}
+
+func TestServerRecovery_versionConflict(t *testing.T) {
+	checkErr := errors.New("exit status 1")
+
+	tests := []struct {
+		name         string
+		client       func(t *testing.T) *adbclient.Client
+		checkErr     error
+		wantServer   int
+		wantClient   int
+		wantConflict bool
+	}{
+		{
+			name:         "adb binary reports the conflict",
+			checkErr:     errors.New("adb server version (39) doesn't match this client (41); killing..."),
+			wantServer:   39,
+			wantClient:   41,
+			wantConflict: true,
+		},
+		{
+			name:     "no native client",
+			checkErr: checkErr,
+		},
+		{
+			name:     "native client, same version",
+			client:   func(t *testing.T) *adbclient.Client { return versionServer(t, 41) },
+			checkErr: checkErr,
+		},
+		{
+			name:         "native client, foreign server",
+			client:       func(t *testing.T) *adbclient.Client { return versionServer(t, 39) },
+			checkErr:     checkErr,
+			wantServer:   39,
+			wantClient:   41,
+			wantConflict: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := Model{version: Version{Bridge: "1.0.41"}, logger: log.NewLogger()}
+			if tt.client != nil {
+				model.client = tt.client(t)
+			}
+
+			recovery := newServerRecovery(model, "emulator-5554", 3)
+			server, client, conflict := recovery.versionConflict(context.Background(), tt.checkErr)
+			if conflict != tt.wantConflict {
+				t.Errorf("conflict = %t, want %t", conflict, tt.wantConflict)
+			}
+			if conflict && (server != tt.wantServer || client != tt.wantClient) {
+				t.Errorf("versions = %d, %d, want %d, %d", server, client, tt.wantServer, tt.wantClient)
+			}
+		})
+	}
+}
+
+func TestServerRecovery_versionConflictOfBinary(t *testing.T) {
+	binPth := filepath.Join(t.TempDir(), "adb")
+	script := "#!/usr/bin/env bash\necho \"adb server version (39) doesn't match this client (41); killing...\"\nexit 1\n"
+	if err := os.WriteFile(binPth, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	model := Model{binPth: binPth, cmdFactory: NewCommandFactory(env.NewRepository()), version: Version{Bridge: "1.0.41"}, logger: log.NewLogger()}
+
+	_, checkErr := model.shellOutput(context.Background(), "emulator-5554", "getprop sys.boot_completed")
+	if checkErr == nil {
+		t.Fatal("expected an error")
+	}
+
+	server, client, conflict := newServerRecovery(model, "emulator-5554", 3).versionConflict(context.Background(), checkErr)
+	if !conflict || server != 39 || client != 41 {
+		t.Errorf("versionConflict(%v) = %d, %d, %t, want 39, 41, true", checkErr, server, client, conflict)
+	}
+}
@@ -0,0 +1,79 @@
+package adbmanager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// BinaryCandidate is a possible location of the adb binary.
+type BinaryCandidate struct {
+	// Source tells where the candidate comes from, e.g. ANDROID_SDK_ROOT.
+	Source string
+	// Path is the path of the binary, or a binary name to look up in PATH.
+	Path string
+}
+
+// PlatformToolsCandidate returns the adb binary in the platform-tools of the SDK.
+// The path is empty if sdkRoot is empty.
+func PlatformToolsCandidate(source, sdkRoot string) BinaryCandidate {
+	if sdkRoot == "" {
+		return BinaryCandidate{Source: source}
+	}
+	return BinaryCandidate{Source: source, Path: filepath.Join(sdkRoot, "platform-tools", "adb")}
+}
+
+// PathCandidate looks up adb in PATH.
+var PathCandidate = BinaryCandidate{Source: "PATH", Path: "adb"}
+
+// ResolveBinary returns the first candidate that is an executable file, logging each candidate it checks.
+// Candidates with an empty path are skipped, e.g. if the env var they come from is not set, as well as
+// the ones with a path that was already checked.
+func ResolveBinary(candidates []BinaryCandidate, logger log.Logger) (string, error) {
+	var checked []string
+	seen := map[string]bool{}
+	for _, candidate := range candidates {
+		if candidate.Path == "" || seen[candidate.Path] {
+			continue
+		}
+		seen[candidate.Path] = true
+
+		pth, err := checkBinary(candidate.Path)
+		if err != nil {
+			logger.Printf("adb from %s: %s", candidate.Source, err)
+			checked = append(checked, candidate.Source)
+			continue
+		}
+		logger.Printf("adb from %s: %s", candidate.Source, pth)
+		return pth, nil
+	}
+	return "", fmt.Errorf("adb not found, checked: %s", strings.Join(checked, ", "))
+}
+
+func checkBinary(pth string) (string, error) {
+	if !strings.Contains(pth, string(filepath.Separator)) {
+		found, err := exec.LookPath(pth)
+		if err != nil {
+			return "", fmt.Errorf("not found in PATH")
+		}
+		pth = found
+	}
+
+	info, err := os.Stat(pth)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("%s does not exist", pth)
+	} else if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", pth)
+	}
+	if info.Mode()&0111 == 0 {
+		return "", fmt.Errorf("%s is not executable", pth)
+	}
+	return pth, nil
+}
@@ -0,0 +1,46 @@
+package adbmanager
+
+import (
+	"context"
+	"fmt"
+)
+
+// Setting is a value of the Android settings provider, see `adb shell settings`.
+type Setting struct {
+	// Namespace is one of global, secure or system.
+	Namespace string
+	Key       string
+	Value     string
+}
+
+func (s Setting) String() string {
+	return fmt.Sprintf("%s:%s=%s", s.Namespace, s.Key, s.Value)
+}
+
+// GetSetting returns the current value of the setting, "null" is returned for unset settings.
+func (model Model) GetSetting(ctx context.Context, serial, namespace, key string) (string, error) {
+	cmd := model.ShellCmd(ctx, serial, nil, "settings", "get", namespace, shellQuote(key))
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", err, out)
+	}
+	return out, nil
+}
+
+// PutSetting writes the setting, then reads it back to verify that the device accepted the value.
+func (model Model) PutSetting(ctx context.Context, serial string, setting Setting) error {
+	cmd := model.ShellCmd(ctx, serial, nil, "settings", "put", setting.Namespace, shellQuote(setting.Key), shellQuote(setting.Value))
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+
+	value, err := model.GetSetting(ctx, serial, setting.Namespace, setting.Key)
+	if err != nil {
+		return err
+	}
+	if value != setting.Value {
+		return fmt.Errorf("value is %s after setting it to %s", value, setting.Value)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package adbmanager
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrNotEnoughStorage is returned by WaitForDevice if the data partition doesn't have the required free space.
+var ErrNotEnoughStorage = errors.New("not enough free space on the data partition")
+
+// storageCommand prints the encryption state and the free space of the data partition.
+// Example output (toybox df, API 23+):
+// decrypt=trigger_restart_framework
+// crypto=encrypted
+// Filesystem      1K-blocks   Used Available Use% Mounted on
+// /dev/block/dm-0   5939196 987652   4935160  17% /data
+//
+// Example output (toolbox df, API 21-22, it doesn't know -k and prints human readable sizes):
+// decrypt=
+// crypto=unencrypted
+// -k: No such file or directory
+// Filesystem               Size     Used     Free   Blksize
+// /data                  774.9M   203.4M   571.5M   4096
+const storageCommand = `echo "decrypt=$(getprop vold.decrypt)"; echo "crypto=$(getprop ro.crypto.state)"; df -k /data`
+
+// vold.decrypt values while a full-disk encrypted data partition is not usable yet.
+var decryptingStates = []string{"trigger_encryption", "trigger_default_encryption", "trigger_restart_min_framework", "1"}
+
+// StorageProbe returns a probe that passes once the data partition is mounted and decrypted.
+// If minFreeMB is positive, the wait fails with ErrNotEnoughStorage if the partition has less free space.
+func StorageProbe(minFreeMB int) ReadinessProbe {
+	return ReadinessProbe{
+		Name:    ProbeStorage,
+		Command: storageCommand,
+		IsReady: func(out string) bool {
+			status, err := parseStorageStatus(out)
+			return err == nil && status.mounted && status.cryptoState != "" && !status.decrypting
+		},
+		Verify: func(out string) error {
+			if minFreeMB <= 0 {
+				return nil
+			}
+			status, err := parseStorageStatus(out)
+			if err != nil {
+				return err
+			}
+			if freeMB := status.availableKB / 1024; freeMB < int64(minFreeMB) {
+				return fmt.Errorf("%w: userdata has %d MB free, need %d MB, use a larger partition size (e.g. -partition-size or disk.dataPartition.size in the AVD config) or wipe the emulator data",
+					ErrNotEnoughStorage, freeMB, minFreeMB)
+			}
+			return nil
+		},
+	}
+}
+
+type storageStatus struct {
+	// cryptoState is ro.crypto.state, init sets it (e.g. to encrypted or unencrypted) once it mounted the data partition.
+	cryptoState string
+	decrypting  bool
+	mounted     bool
+	availableKB int64
+}
+
+func parseStorageStatus(out string) (storageStatus, error) {
+	var status storageStatus
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "decrypt="); ok {
+			for _, state := range decryptingStates {
+				if value == state {
+					status.decrypting = true
+				}
+			}
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "crypto="); ok {
+			status.cryptoState = value
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) >= 6 && fields[len(fields)-1] == "/data":
+			// toybox: Filesystem 1K-blocks Used Available Use% Mounted on
+			available, err := strconv.ParseInt(fields[3], 10, 64)
+			if err != nil {
+				return storageStatus{}, fmt.Errorf("invalid df output (%s): %w", line, err)
+			}
+			status.mounted = true
+			status.availableKB = available
+		case len(fields) == 5 && fields[0] == "/data":
+			// toolbox: Filesystem Size Used Free Blksize
+			available, err := parseToolboxSize(fields[3])
+			if err != nil {
+				return storageStatus{}, fmt.Errorf("invalid df output (%s): %w", line, err)
+			}
+			status.mounted = true
+			status.availableKB = available
+		}
+	}
+	return status, nil
+}
+
+// parseToolboxSize converts the human readable sizes of toolbox df (e.g. 571.5M) to kilobytes.
+func parseToolboxSize(size string) (int64, error) {
+	multiplier := map[byte]float64{'K': 1, 'M': 1024, 'G': 1024 * 1024}
+	if size == "" || multiplier[size[len(size)-1]] == 0 {
+		return 0, fmt.Errorf("unknown size unit: %s", size)
+	}
+
+	value, err := strconv.ParseFloat(size[:len(size)-1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(value * multiplier[size[len(size)-1]]), nil
+}
@@ -0,0 +1,126 @@
+package adbmanager
+
+import (
+	"errors"
+	"testing"
+)
+
+const (
+	toyboxStorageOutput = `decrypt=trigger_restart_framework
+crypto=encrypted
+Filesystem      1K-blocks   Used Available Use% Mounted on
+/dev/block/dm-0   5939196 987652   4935160  17% /data`
+
+	toolboxStorageOutput = `decrypt=
+crypto=unencrypted
+-k: No such file or directory
+Filesystem               Size     Used     Free   Blksize
+/data                  774.9M   203.4M   571.5M   4096`
+)
+
+func TestParseStorageStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    storageStatus
+		wantErr bool
+	}{
+		{
+			name: "toybox",
+			out:  toyboxStorageOutput,
+			want: storageStatus{cryptoState: "encrypted", mounted: true, availableKB: 4935160},
+		},
+		{
+			name: "toolbox",
+			out:  toolboxStorageOutput,
+			want: storageStatus{cryptoState: "unencrypted", mounted: true, availableKB: 585216},
+		},
+		{
+			name: "toolbox in gigabytes",
+			out: `crypto=unencrypted
+Filesystem               Size     Used     Free   Blksize
+/data                    5.8G     1.2G     4.6G   4096`,
+			want: storageStatus{cryptoState: "unencrypted", mounted: true, availableKB: 4823449},
+		},
+		{
+			name: "decrypting",
+			out: `decrypt=trigger_restart_min_framework
+crypto=encrypted
+Filesystem     1K-blocks  Used Available Use% Mounted on
+tmpfs             999536     0    999536   0% /data`,
+			want: storageStatus{cryptoState: "encrypted", decrypting: true, mounted: true, availableKB: 999536},
+		},
+		{
+			name: "not mounted yet",
+			out: `decrypt=
+crypto=
+df: /data: No such file or directory`,
+			want: storageStatus{},
+		},
+		{
+			name: "invalid toybox size",
+			out: `Filesystem      1K-blocks   Used Available Use% Mounted on
+/dev/block/dm-0   5939196 987652   unknown  17% /data`,
+			wantErr: true,
+		},
+		{
+			name: "invalid toolbox size",
+			out: `Filesystem               Size     Used     Free   Blksize
+/data                  774.9M   203.4M   571.5T   4096`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStorageStatus(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStorageStatus() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseStorageStatus() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorageProbe(t *testing.T) {
+	tests := []struct {
+		name          string
+		minFreeMB     int
+		out           string
+		wantReady     bool
+		wantNotEnough bool
+	}{
+		{name: "toybox ready", minFreeMB: 1024, out: toyboxStorageOutput, wantReady: true},
+		{name: "toolbox ready", minFreeMB: 512, out: toolboxStorageOutput, wantReady: true},
+		{name: "toolbox not enough space", minFreeMB: 1024, out: toolboxStorageOutput, wantReady: true, wantNotEnough: true},
+		{
+			name: "crypto state not set yet",
+			out: `decrypt=
+crypto=
+Filesystem      1K-blocks   Used Available Use% Mounted on
+/dev/block/dm-0   5939196 987652   4935160  17% /data`,
+		},
+		{
+			name: "decrypting",
+			out: `decrypt=trigger_restart_min_framework
+crypto=encrypted
+Filesystem     1K-blocks  Used Available Use% Mounted on
+tmpfs             999536     0    999536   0% /data`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			probe := StorageProbe(tt.minFreeMB)
+			if got := probe.IsReady(tt.out); got != tt.wantReady {
+				t.Fatalf("IsReady() = %t, want %t", got, tt.wantReady)
+			}
+			if !tt.wantReady {
+				return
+			}
+			if err := probe.Verify(tt.out); errors.Is(err, ErrNotEnoughStorage) != tt.wantNotEnough {
+				t.Errorf("Verify() error = %v, want not enough storage: %t", err, tt.wantNotEnough)
+			}
+		})
+	}
+}
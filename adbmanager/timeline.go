@@ -0,0 +1,121 @@
+package adbmanager
+
+import (
+	"context"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// Boot phases, in the order they are expected to happen.
+const (
+	PhaseVisible            = "adb_visible"
+	PhaseOnline             = "state_device"
+	PhaseBootAnimation      = "bootanim_running"
+	PhaseBootCompleted      = "boot_completed"
+	PhasePackageManager     = "package_manager_ready"
+	PhaseReady              = "ready"
+	PhaseUnlocked           = "unlocked"
+	PhaseEmulatorRestarting = "emulator_restarting"
+)
+
+// Phase is a boot phase reached at Time.
+type Phase struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+	// SinceStartMs is the time elapsed since the start of the wait until the phase was reached.
+	SinceStartMs int64 `json:"since_start_ms"`
+	// DurationMs is the time elapsed since the previous phase.
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// Timeline records when the device reached each boot phase.
+type Timeline struct {
+	Serial string    `json:"serial"`
+	Start  time.Time `json:"start"`
+	Phases []Phase   `json:"phases"`
+
+	reached map[string]bool
+}
+
+// NewTimeline ...
+func NewTimeline(serial string) *Timeline {
+	return &Timeline{
+		Serial:  serial,
+		Start:   time.Now(),
+		reached: map[string]bool{},
+	}
+}
+
+// Mark records the phase, if it wasn't reached yet.
+func (t *Timeline) Mark(name string) {
+	if t.reached[name] {
+		return
+	}
+	t.reached[name] = true
+
+	now := time.Now()
+	previous := t.Start
+	if len(t.Phases) > 0 {
+		previous = t.Phases[len(t.Phases)-1].Time
+	}
+
+	t.Phases = append(t.Phases, Phase{
+		Name:         name,
+		Time:         now,
+		SinceStartMs: now.Sub(t.Start).Milliseconds(),
+		DurationMs:   now.Sub(previous).Milliseconds(),
+	})
+}
+
+// Reached tells if the phase was already recorded.
+func (t *Timeline) Reached(name string) bool {
+	return t.reached[name]
+}
+
+// MarkRestart records that the emulator is restarting, the boot phases can be reached again afterwards.
+func (t *Timeline) MarkRestart() {
+	t.reached = map[string]bool{}
+	t.Mark(PhaseEmulatorRestarting)
+}
+
+// Print logs the phases with their timestamps and durations.
+func (t *Timeline) Print(logger log.Logger) {
+	logger.Printf("Boot timeline of %s:", t.Serial)
+	for _, phase := range t.Phases {
+		logger.Printf("- %s %-22s +%6.1fs (%.1fs since start)", phase.Time.Format("15:04:05.000"), phase.Name,
+			float64(phase.DurationMs)/1000, float64(phase.SinceStartMs)/1000)
+	}
+}
+
+// recordBootPhases marks the phases the device reached since the last check.
+// The state is empty if the device list could not be queried.
+func (model *Model) recordBootPhases(ctx context.Context, serial string, timeline *Timeline, state string, stage map[string]string) {
+	if state != "" && state != StateMissing {
+		timeline.Mark(PhaseVisible)
+	}
+	if state == StateDevice {
+		timeline.Mark(PhaseOnline)
+	}
+	if !timeline.Reached(PhaseOnline) {
+		return
+	}
+
+	// A fast boot might skip observing the running boot animation, a stopped one means it ran already.
+	if bootanim := stage["init.svc.bootanim"]; bootanim == "running" || bootanim == "stopped" {
+		timeline.Mark(PhaseBootAnimation)
+	}
+
+	if stage["sys.boot_completed"] != "1" {
+		return
+	}
+	timeline.Mark(PhaseBootCompleted)
+
+	if !timeline.Reached(PhasePackageManager) {
+		if probe, ok := findReadinessProbe(ProbePackageManager); ok {
+			if out, err := model.runProbe(ctx, serial, probe); err == nil && probe.IsReady(out) {
+				timeline.Mark(PhasePackageManager)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrDeviceLocked is returned by UnlockDevice if the keyguard is still showing after the unlock attempts.
+var ErrDeviceLocked = errors.New("keyguard is still showing, the device screen is locked")
+
+const (
+	keyCodeWakeUp = "224" // KEYCODE_WAKEUP
+	keyCodeMenu   = "82"  // KEYCODE_MENU, dismisses a keyguard without security
+)
+
+const (
+	unlockAttempts   = 3
+	unlockCheckDelay = 2 * time.Second
+)
+
+// keyguardShowingMarkers are the `dumpsys window` lines signaling a visible keyguard,
+// the exact format depends on the API level.
+var keyguardShowingMarkers = []string{
+	"mShowingLockscreen=true",
+	"mDreamingLockscreen=true",
+	"isStatusBarKeyguard=true",
+	"KeyguardShowing=true",
+}
+
+// UnlockDevice wakes the device up and dismisses its keyguard, then verifies that the keyguard is actually gone.
+// ErrDeviceLocked is returned if the device is still locked after the attempts.
+func (model Model) UnlockDevice(ctx context.Context, serial string) error {
+	for attempt := 1; attempt <= unlockAttempts; attempt++ {
+		if err := model.runShell(ctx, serial, "input", "keyevent", keyCodeWakeUp); err != nil {
+			return fmt.Errorf("wake up device: %w", err)
+		}
+
+		// `wm dismiss-keyguard` is available from API 26, the menu key event works on older images too.
+		if err := model.runShell(ctx, serial, "wm", "dismiss-keyguard"); err != nil {
+			model.logger.Debugf("wm dismiss-keyguard failed: %s", err)
+		}
+		if err := model.runShell(ctx, serial, "input", "keyevent", keyCodeMenu); err != nil {
+			return fmt.Errorf("send menu key event: %w", err)
+		}
+
+		// Dismissing the keyguard is asynchronous.
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(unlockCheckDelay):
+		}
+
+		showing, err := model.IsKeyguardShowing(ctx, serial)
+		if err != nil {
+			return err
+		}
+		if !showing {
+			return nil
+		}
+
+		model.logger.Warnf("Keyguard is still showing (attempt %d/%d)", attempt, unlockAttempts)
+	}
+
+	return ErrDeviceLocked
+}
+
+// IsKeyguardShowing tells if the lock screen is visible based on `dumpsys window`.
+func (model Model) IsKeyguardShowing(ctx context.Context, serial string) (bool, error) {
+	cmd := model.ShellCmd(ctx, serial, nil, "dumpsys", "window")
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if err != nil {
+		return false, fmt.Errorf("check keyguard state: %s: %s", err, out)
+	}
+
+	for _, line := range strings.Split(out, "\n") {
+		for _, marker := range keyguardShowingMarkers {
+			if strings.Contains(line, marker) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (model Model) runShell(ctx context.Context, serial string, commands ...string) error {
+	cmd := model.ShellCmd(ctx, serial, nil, commands...)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return nil
+}
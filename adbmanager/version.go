@@ -0,0 +1,103 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-version"
+)
+
+// versionTimeout limits `adb version`, a binary that doesn't answer in time is unusable for the boot checks.
+const versionTimeout = 10 * time.Second
+
+// Version is the version of an adb binary.
+type Version struct {
+	// Bridge is the Android Debug Bridge version, e.g. 1.0.41.
+	Bridge string
+	// PlatformTools is the platform-tools revision, e.g. 34.0.5. It is empty for binaries older than platform-tools 27.
+	PlatformTools string
+}
+
+func (v Version) String() string {
+	if v.PlatformTools == "" {
+		return v.Bridge
+	}
+	return fmt.Sprintf("%s (platform-tools %s)", v.Bridge, v.PlatformTools)
+}
+
+// Protocol returns the adb protocol version, the last component of the bridge version (41 for 1.0.41).
+// The adb server reports the same number for host:version.
+func (v Version) Protocol() (int, bool) {
+	parts := strings.Split(v.Bridge, ".")
+	protocol, err := strconv.Atoi(parts[len(parts)-1])
+	return protocol, err == nil
+}
+
+// ParseVersion parses the output of `adb version`.
+func ParseVersion(out string) (Version, error) {
+	// Example output:
+	// Android Debug Bridge version 1.0.41
+	// Version 34.0.5-10900879
+	// Installed as /usr/local/lib/android/sdk/platform-tools/adb
+	var adbVersion Version
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if value, ok := strings.CutPrefix(line, "Android Debug Bridge version "); ok {
+			adbVersion.Bridge = value
+		} else if value, ok := strings.CutPrefix(line, "Version "); ok {
+			adbVersion.PlatformTools, _, _ = strings.Cut(value, "-")
+		}
+	}
+
+	if adbVersion.Bridge == "" {
+		return Version{}, fmt.Errorf("no Android Debug Bridge version in the output: %s", out)
+	}
+	return adbVersion, nil
+}
+
+// checkVersion runs `adb version` and fails if the binary can't be executed in time or it is older than
+// the minimum platform-tools version. An empty minimum accepts any version.
+func (model Model) checkVersion(minVersion string) (Version, error) {
+	var min *version.Version
+	if minVersion != "" {
+		var err error
+		if min, err = version.NewVersion(minVersion); err != nil {
+			return Version{}, fmt.Errorf("invalid minimum adb version (%s), use a platform-tools version like 30.0.0: %w", minVersion, err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), versionTimeout)
+	defer cancel()
+
+	cmd := model.cmdFactory.Create(ctx, model.binPth, []string{"version"}, nil)
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Version{}, fmt.Errorf("adb version did not finish in %s", versionTimeout)
+	} else if err != nil {
+		return Version{}, fmt.Errorf("failed to run adb, check that it is executable and built for this platform: %s: %s", err, out)
+	}
+
+	adbVersion, err := ParseVersion(out)
+	if err != nil {
+		return Version{}, err
+	}
+
+	if min != nil && !adbVersion.atLeast(min) {
+		return adbVersion, fmt.Errorf("adb %s is older than the minimum platform-tools version %s", adbVersion, minVersion)
+	}
+	return adbVersion, nil
+}
+
+// atLeast tells if the platform-tools version is not older than min. The binaries older than platform-tools 27
+// don't report their platform-tools version, they are older than any minimum.
+func (v Version) atLeast(min *version.Version) bool {
+	if v.PlatformTools == "" {
+		return false
+	}
+	platformTools, err := version.NewVersion(v.PlatformTools)
+	return err == nil && !platformTools.LessThan(min)
+}
@@ -0,0 +1,74 @@
+package adbmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    Version
+		wantErr bool
+	}{
+		{
+			name: "platform-tools",
+			out:  "Android Debug Bridge version 1.0.41\nVersion 34.0.5-10900879\nInstalled as /usr/local/lib/android/sdk/platform-tools/adb\n",
+			want: Version{Bridge: "1.0.41", PlatformTools: "34.0.5"},
+		},
+		{
+			name: "binary without a Version line",
+			out:  "Android Debug Bridge version 1.0.39\nRevision 3db08f2c6889-android\n",
+			want: Version{Bridge: "1.0.39"},
+		},
+		{name: "not adb", out: "bash: adb: command not found", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVersion(tt.out)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersion() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVersion() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		minVersion string
+		wantErr    bool
+	}{
+		{name: "no minimum", out: "Android Debug Bridge version 1.0.39", minVersion: ""},
+		{name: "newer", out: "Android Debug Bridge version 1.0.41\nVersion 34.0.5-10900879", minVersion: "30.0.0"},
+		{name: "same", out: "Android Debug Bridge version 1.0.41\nVersion 34.0.5-10900879", minVersion: "34.0.5"},
+		{name: "newer with more components", out: "Android Debug Bridge version 1.0.41\nVersion 34.0.10-11190730", minVersion: "34.0.9"},
+		{name: "older", out: "Android Debug Bridge version 1.0.41\nVersion 29.0.6-6198805", minVersion: "30.0.0", wantErr: true},
+		{name: "binary without a Version line", out: "Android Debug Bridge version 1.0.39", minVersion: "30.0.0", wantErr: true},
+		{name: "invalid minimum", out: "Android Debug Bridge version 1.0.41\nVersion 34.0.5-10900879", minVersion: "latest", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binPth := filepath.Join(t.TempDir(), "adb")
+			script := "#!/usr/bin/env bash\nprintf '%s\\n' \"$VERSION_OUTPUT\"\n"
+			if err := os.WriteFile(binPth, []byte(script), 0755); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("VERSION_OUTPUT", tt.out)
+
+			model := Model{binPth: binPth, cmdFactory: NewCommandFactory(env.NewRepository()), logger: log.NewLogger()}
+			if _, err := model.checkVersion(tt.minVersion); (err != nil) != tt.wantErr {
+				t.Errorf("checkVersion(%q) error = %v, wantErr %t", tt.minVersion, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrDeviceNotFound is returned by WaitForDevice if the serial didn't appear within WaitOptions.DeviceGracePeriod.
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrDeviceUnauthorized is returned by WaitForDevice if the device is unauthorized for WaitOptions.DeviceGracePeriod.
+	ErrDeviceUnauthorized = errors.New("device is unauthorized")
+)
+
+// stateQueryTimeout limits the device list and boot stage queries, they only inform the boot check,
+// so they must not use up the whole attempt.
+const stateQueryTimeout = 10 * time.Second
+
+// ErrBootStalled is returned by WaitForDevice if the boot made no progress within WaitOptions.StallTimeout.
+var ErrBootStalled = errors.New("emulator boot stalled")
+
+// WaitOptions configures WaitForDevice.
+type WaitOptions struct {
+	// Probes have to pass before the device is reported as ready. If empty, DefaultReadinessProbes is used.
+	Probes []ReadinessProbe
+	// StallTimeout is the time without any change in the boot stage properties and the pending probes
+	// after which ErrBootStalled is returned, only the checks that reached the device are compared.
+	// ErrBootStalled is returned as well if a device that was already seen is unreachable for this long.
+	// Zero disables the stall detection.
+	StallTimeout time.Duration
+	// Timeline records the boot phases. If nil, a new timeline is started.
+	Timeline *Timeline
+	// PollStrategy tells how long to wait between the boot checks. If nil, the checks are 5 seconds apart.
+	PollStrategy PollStrategy
+	// AttemptTimeout limits a single boot check, so a hung adb command doesn't use up the whole wait.
+	// Zero means that only the context limits the boot checks.
+	AttemptTimeout time.Duration
+	// DeviceGracePeriod is the time after which the wait fails with ErrDeviceNotFound if the serial was never listed
+	// by adb, or with ErrDeviceUnauthorized if the device is unauthorized for this long. Zero disables these checks.
+	DeviceGracePeriod time.Duration
+	// ServerRestartThreshold is the number of consecutive failed boot checks after which the adb server is restarted,
+	// the device is reconnected before that. Zero or one restarts the server on the first failure.
+	ServerRestartThreshold int
+}
+
+// BootStats describes how the device boot wait went.
+type BootStats struct {
+	// Duration is the time it took for the device to get ready.
+	Duration time.Duration
+	// Retries is the number of boot checks that had to be repeated.
+	Retries int
+	// ServerRestarts is the number of times the adb server was killed to recover from an error.
+	ServerRestarts int
+	// Timeline records when the device reached each boot phase.
+	Timeline *Timeline
+}
+
+// WaitForDevice polls the device until every probe reports ready or the context is done.
+func (model Model) WaitForDevice(ctx context.Context, serial string, opts WaitOptions) (BootStats, error) {
+	probes := opts.Probes
+	if len(probes) == 0 {
+		probes = DefaultReadinessProbes()
+	}
+
+	timeline := opts.Timeline
+	if timeline == nil {
+		timeline = NewTimeline(serial)
+	}
+	pollStrategy := opts.PollStrategy
+	if pollStrategy == nil {
+		pollStrategy = FixedPoll{Interval: defaultPollInterval}
+	}
+
+	recovery := newServerRecovery(model, serial, opts.ServerRestartThreshold)
+
+	stats := BootStats{Timeline: timeline}
+	startTime := time.Now()
+	var pending []string
+	timeoutErr := func() (BootStats, error) {
+		stats.Duration = time.Since(startTime)
+		if len(pending) > 0 {
+			return stats, fmt.Errorf("emulator (%s) boot check timed out after %d seconds, still waiting for: %s", serial, stats.Duration/time.Second, strings.Join(pending, ", "))
+		}
+		return stats, fmt.Errorf("emulator (%s) boot check timed out after %d seconds", serial, stats.Duration/time.Second)
+	}
+
+	var lastProgress string
+	lastProgressTime := time.Now()
+	lastReachableTime := time.Now()
+	var lastState string
+	lastStateTime := time.Now()
+	seen := false
+
+	for attempt := 0; ; attempt++ {
+		stats.Retries = attempt
+		model.logger.Printf("Waiting for emulator (%s) to boot...", serial)
+
+		state, stage, result := model.checkAttempt(ctx, serial, probes, timeline, opts.AttemptTimeout)
+		if state != "" && state != lastState {
+			if lastState == "" {
+				model.logger.Printf("Device (%s) state: %s", serial, state)
+			} else {
+				model.logger.Printf("Device (%s) state: %s -> %s", serial, lastState, state)
+			}
+			lastState = state
+			lastStateTime = time.Now()
+		}
+		seen = seen || (state != "" && state != StateMissing)
+		if state == StateMissing && IsNetworkSerial(serial) {
+			model.reconnect(ctx, serial)
+		}
+		if err := checkDeviceState(serial, state, seen, startTime, lastStateTime, opts.DeviceGracePeriod); err != nil && !result.Booted {
+			stats.Duration = time.Since(startTime)
+			return stats, err
+		}
+		if len(result.Pending) > 0 {
+			pending = result.Pending
+		}
+		// A check that didn't reach the device (e.g. it is still offline after a reconnect) doesn't end the recovery.
+		if reachable(state, result) {
+			recovery.succeeded()
+		}
+		switch {
+		case result.Booted:
+			timeline.Mark(PhaseReady)
+			stats.Duration = time.Since(startTime)
+			model.logger.Donef("Device (%s) boot completed in %d seconds", serial, stats.Duration/time.Second)
+			return stats, nil
+		case result.Failure != nil:
+			stats.Duration = time.Since(startTime)
+			return stats, result.Failure
+		case ctx.Err() != nil:
+			return timeoutErr()
+		case result.Error != nil:
+			model.logger.Warnf("Failed to check emulator (%s) boot status: %s", serial, result.Error)
+			level, err := recovery.recover(ctx, result.Error)
+			if ctx.Err() != nil {
+				return timeoutErr()
+			}
+			if err != nil {
+				// The next check tells if the adb server is still unusable, escalating further if needed.
+				model.logger.Warnf("%s", err)
+			} else if level == RecoveryServerRestart {
+				stats.ServerRestarts++
+			}
+		}
+
+		// The boot stage can't be read while the device is unreachable, these attempts tell nothing about the progress.
+		// A device that disappears or goes offline after it was seen (e.g. the emulator crashed) is stalled as well.
+		if opts.StallTimeout > 0 {
+			if reachable(state, result) || !seen {
+				lastReachableTime = time.Now()
+			} else if unreachableFor := time.Since(lastReachableTime); unreachableFor >= opts.StallTimeout {
+				stats.Duration = time.Since(startTime)
+				return stats, fmt.Errorf("%w: %s is unreachable (%s) for %d seconds", ErrBootStalled, serial, formatState(state), unreachableFor/time.Second)
+			}
+
+			if reachable(state, result) {
+				progress := strings.Join(result.Pending, ", ") + "; " + formatBootStage(stage)
+				if progress != lastProgress {
+					lastProgress = progress
+					lastProgressTime = time.Now()
+				} else if stalledFor := time.Since(lastProgressTime); stalledFor >= opts.StallTimeout {
+					stats.Duration = time.Since(startTime)
+					return stats, fmt.Errorf("%w: no boot progress on %s in the last %d seconds (%s)", ErrBootStalled, serial, stalledFor/time.Second, progress)
+				}
+			}
+		}
+
+		delay := pollStrategy.Delay(attempt + 1)
+		if len(result.Pending) == 1 && result.Pending[0] == deviceOnlinePending {
+			model.logger.Printf("Device (%s) is not online yet, retrying in %.1f seconds", serial, delay.Seconds())
+		} else if len(result.Pending) > 0 {
+			model.logger.Printf("Device (%s) is online but still booting (waiting for: %s), retrying in %.1f seconds", serial, strings.Join(result.Pending, ", "), delay.Seconds())
+		} else {
+			model.logger.Printf("Device (%s) is online but still booting, retrying in %.1f seconds", serial, delay.Seconds())
+		}
+
+		select {
+		case <-ctx.Done():
+			return timeoutErr()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// checkAttempt runs a single boot check, limited by attemptTimeout if it is set.
+// The returned state is empty if the device list could not be queried.
+func (model Model) checkAttempt(ctx context.Context, serial string, probes []ReadinessProbe, timeline *Timeline, attemptTimeout time.Duration) (string, map[string]string, WaitForBootCompleteResult) {
+	if attemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, attemptTimeout)
+		defer cancel()
+	}
+
+	state := model.queryDeviceState(ctx, serial)
+	if !canRunCommands(state) {
+		// Shell commands would fail or block until the attempt timeout, which is not an adb error to recover from.
+		model.recordBootPhases(ctx, serial, timeline, state, nil)
+		return state, nil, WaitForBootCompleteResult{Pending: []string{deviceOnlinePending}}
+	}
+
+	stageCtx, cancelStage := context.WithTimeout(ctx, stateQueryTimeout)
+	stage := model.bootStage(stageCtx, serial)
+	cancelStage()
+	model.recordBootPhases(ctx, serial, timeline, state, stage)
+
+	result := model.checkBootComplete(ctx, serial, probes)
+	if result.Error != nil && errors.Is(result.Error, context.DeadlineExceeded) && ctx.Err() != nil {
+		result.Error = fmt.Errorf("boot check did not finish in %s: %w", attemptTimeout, result.Error)
+	}
+	return state, stage, result
+}
+
+// reachable tells if the boot check could talk to the device, so its pending probes and boot stage are meaningful.
+func reachable(state string, result WaitForBootCompleteResult) bool {
+	return result.Error == nil && state != "" && canRunCommands(state)
+}
+
+// formatState describes the state of an unreachable device for the logs.
+func formatState(state string) string {
+	if state == "" {
+		return "device list not available"
+	}
+	return state
+}
+
+// canRunCommands tells if the device state allows running shell commands, the missing, offline and unauthorized
+// devices only get online by waiting. An empty state (the device list could not be queried) is not decided on.
+func canRunCommands(state string) bool {
+	switch state {
+	case StateMissing, StateOffline, StateUnauthorized:
+		return false
+	}
+	return true
+}
+
+// queryDeviceState returns the state of the device in the `adb devices` list, or an empty string if the list
+// could not be queried.
+func (model Model) queryDeviceState(ctx context.Context, serial string) string {
+	ctx, cancel := context.WithTimeout(ctx, stateQueryTimeout)
+	defer cancel()
+
+	devices, err := model.Devices(ctx)
+	if err != nil {
+		model.logger.Debugf("Failed to list devices: %s", err)
+		return ""
+	}
+	return deviceState(devices, serial)
+}
+
+// reconnect connects the network device again, after its transport dropped (e.g. the emulator restarted).
+func (model Model) reconnect(ctx context.Context, serial string) {
+	ctx, cancel := context.WithTimeout(ctx, stateQueryTimeout)
+	defer cancel()
+
+	model.logger.Printf("Device (%s) is not connected, reconnecting...", serial)
+	if err := model.Connect(ctx, serial); err != nil {
+		model.logger.Warnf("Failed to reconnect device (%s): %s", serial, err)
+	}
+}
+
+// checkDeviceState fails fast if the serial never appeared or the device is stuck in a state that won't resolve by waiting.
+func checkDeviceState(serial, state string, seen bool, startTime, stateTime time.Time, gracePeriod time.Duration) error {
+	if gracePeriod == 0 {
+		return nil
+	}
+
+	switch {
+	case state == StateMissing && !seen && time.Since(startTime) >= gracePeriod:
+		return fmt.Errorf("%w: %s is not listed by adb devices after %d seconds, check the emulator serial", ErrDeviceNotFound, serial, gracePeriod/time.Second)
+	case state == StateUnauthorized && time.Since(stateTime) >= gracePeriod:
+		return fmt.Errorf("%w: %s is unauthorized for %d seconds, check the adb keys of the emulator", ErrDeviceUnauthorized, serial, gracePeriod/time.Second)
+	}
+	return nil
+}
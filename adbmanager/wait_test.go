@@ -0,0 +1,74 @@
+package adbmanager
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/env"
+	"github.com/bitrise-io/go-utils/v2/log"
+)
+
+// fakeDeviceListAdb lists the device as online for the first $ONLINE_CHECKS device list queries, then it drops
+// from the list, like a crashed emulator. The device never finishes booting.
+const fakeDeviceListAdb = `#!/usr/bin/env bash
+if [[ "$*" == "devices -l" ]]; then
+  count=$(( $(cat "$COUNT_FILE" 2>/dev/null || echo 0) + 1 ))
+  echo "$count" > "$COUNT_FILE"
+  echo "List of devices attached"
+  if [[ "$count" -le "$ONLINE_CHECKS" ]]; then
+    echo "emulator-5554          device product:sdk_gphone64_x86_64 transport_id:1"
+  fi
+fi
+`
+
+func TestWaitForDevice_StallsIfDeviceDisappears(t *testing.T) {
+	dir := t.TempDir()
+	binPth := filepath.Join(dir, "adb")
+	if err := os.WriteFile(binPth, []byte(fakeDeviceListAdb), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("COUNT_FILE", filepath.Join(dir, "count"))
+
+	probe, ok := findReadinessProbe(ProbeBootCompleted)
+	if !ok {
+		t.Fatal("boot_completed probe not found")
+	}
+	opts := WaitOptions{
+		Probes:       []ReadinessProbe{probe},
+		StallTimeout: 500 * time.Millisecond,
+		PollStrategy: FixedPoll{Interval: 50 * time.Millisecond},
+	}
+
+	tests := []struct {
+		name         string
+		onlineChecks string
+		wantStalled  bool
+	}{
+		{name: "device disappears after it was seen", onlineChecks: "2", wantStalled: true},
+		{name: "device never seen", onlineChecks: "0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.RemoveAll(filepath.Join(dir, "count")); err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("ONLINE_CHECKS", tt.onlineChecks)
+
+			model := Model{binPth: binPth, cmdFactory: NewCommandFactory(env.NewRepository()), logger: log.NewLogger()}
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			_, err := model.WaitForDevice(ctx, "emulator-5554", opts)
+			if errors.Is(err, ErrBootStalled) != tt.wantStalled {
+				t.Fatalf("WaitForDevice() error = %v, want stalled: %t", err, tt.wantStalled)
+			}
+			if tt.wantStalled && ctx.Err() != nil {
+				t.Errorf("the stall was detected only at the timeout")
+			}
+		})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/emuconsole"
+)
+
+// allEmulatorsSerial can be passed as the emulator serial to wait for every emulator listed by `adb devices`.
+const allEmulatorsSerial = "*"
+
+const (
+	listDevicesTimeout      = 30 * time.Second
+	bootFailureCauseTimeout = 30 * time.Second
+	detectSerialInterval    = 5 * time.Second
+	connectAttempts         = 5
+	connectTimeout          = 30 * time.Second
+	connectRetryInterval    = 5 * time.Second
+)
+
+type bootResult struct {
+	serial           string
+	stats            adbmanager.BootStats
+	emulatorRestarts int
+	err              error
+}
+
+// parseSerials splits the emulator serial input on newlines and commas.
+func parseSerials(input string) []string {
+	var serials []string
+	seen := map[string]bool{}
+	for _, serial := range strings.FieldsFunc(input, func(r rune) bool { return r == '\n' || r == ',' }) {
+		serial = strings.TrimSpace(serial)
+		if serial == "" || seen[serial] {
+			continue
+		}
+		seen[serial] = true
+		serials = append(serials, serial)
+	}
+	return serials
+}
+
+// resolveSerials returns the serials to wait for. If the input is empty, the single running emulator is detected,
+// waiting up to detectTimeout for it to appear.
+func resolveSerials(ctx context.Context, input string, adb *adbmanager.Model, detectTimeout time.Duration) ([]string, error) {
+	serials := parseSerials(input)
+	if len(serials) == 0 {
+		serial, err := detectSerial(ctx, adb, detectTimeout)
+		if err != nil {
+			return nil, err
+		}
+		return []string{serial}, nil
+	}
+
+	if len(serials) == 1 && serials[0] == allEmulatorsSerial {
+		ctx, cancel := context.WithTimeout(ctx, listDevicesTimeout)
+		defer cancel()
+
+		emulatorSerials, err := adb.EmulatorSerials(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list emulators: %s", err)
+		}
+		if len(emulatorSerials) == 0 {
+			return nil, fmt.Errorf("no emulator is listed by adb devices")
+		}
+		return emulatorSerials, nil
+	}
+
+	for _, serial := range serials {
+		if serial == allEmulatorsSerial {
+			return nil, fmt.Errorf("%s can't be combined with other serials", allEmulatorsSerial)
+		}
+	}
+
+	return serials, nil
+}
+
+// detectSerial looks for running emulators both in the adb device list and on the emulator console ports,
+// as an emulator might not be visible to adb yet. It fails if there are multiple candidates.
+func detectSerial(ctx context.Context, adb *adbmanager.Model, timeout time.Duration) (string, error) {
+	logger.Printf("Emulator serial is not specified, detecting the running emulator...")
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for {
+		candidates := detectEmulators(ctx, adb)
+		switch len(candidates) {
+		case 0:
+		case 1:
+			logger.Donef("Detected emulator: %s", candidates[0])
+			return candidates[0], nil
+		default:
+			return "", fmt.Errorf("multiple emulators are running (%s), set the emulator serial input", strings.Join(candidates, ", "))
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("no running emulator found within %d seconds", timeout/time.Second)
+		case <-time.After(detectSerialInterval):
+		}
+	}
+}
+
+func detectEmulators(ctx context.Context, adb *adbmanager.Model) []string {
+	listCtx, cancel := context.WithTimeout(ctx, listDevicesTimeout)
+	defer cancel()
+
+	serials, err := adb.EmulatorSerials(listCtx)
+	if err != nil {
+		logger.Warnf("Failed to list emulators: %s", err)
+	}
+	serials = append(serials, emuconsole.RunningSerials(ctx)...)
+
+	return parseSerials(strings.Join(serials, "\n"))
+}
+
+// connectNetworkDevices runs `adb connect` for the host:port serials, retrying if the device is not reachable yet.
+func connectNetworkDevices(ctx context.Context, adb *adbmanager.Model, serials []string) ([]string, error) {
+	var connected []string
+	for _, serial := range serials {
+		if !adbmanager.IsNetworkSerial(serial) {
+			continue
+		}
+
+		logger.Printf("Connecting to %s...", serial)
+		if err := connectNetworkDevice(ctx, adb, serial); err != nil {
+			return connected, err
+		}
+		connected = append(connected, serial)
+		logger.Donef("Connected to %s", serial)
+	}
+	return connected, nil
+}
+
+func connectNetworkDevice(ctx context.Context, adb *adbmanager.Model, serial string) error {
+	for attempt := 1; ; attempt++ {
+		connectCtx, cancel := context.WithTimeout(ctx, connectTimeout)
+		err := adb.Connect(connectCtx, serial)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt == connectAttempts {
+			return fmt.Errorf("%s (after %d attempts)", err, attempt)
+		}
+
+		logger.Warnf("Attempt %d/%d: %s, retrying in %d seconds", attempt, connectAttempts, err, connectRetryInterval/time.Second)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(connectRetryInterval):
+		}
+	}
+}
+
+// disconnectNetworkDevices runs `adb disconnect` for the connected network devices.
+func disconnectNetworkDevices(adb *adbmanager.Model, serials []string) {
+	for _, serial := range serials {
+		ctx, cancel := cleanupContext(connectTimeout)
+		if err := adb.Disconnect(ctx, serial); err != nil {
+			logger.Warnf("Failed to disconnect %s: %s", serial, err)
+		} else {
+			logger.Printf("Disconnected %s", serial)
+		}
+		cancel()
+	}
+}
+
+// waitForDevices waits for every device concurrently, until the deadline of ctx.
+// If recovery is not nil, stalled emulators are restarted.
+func waitForDevices(ctx context.Context, adb *adbmanager.Model, serials []string, opts adbmanager.WaitOptions, recovery *emulatorRecovery) []bootResult {
+	results := make([]bootResult, len(serials))
+	var wg sync.WaitGroup
+	for i, serial := range serials {
+		wg.Add(1)
+		go func(i int, serial string) {
+			defer wg.Done()
+
+			var result bootResult
+			if recovery != nil {
+				result = recovery.waitForDevice(ctx, adb, serial, opts)
+			} else {
+				stats, err := adb.WaitForDevice(ctx, serial, opts)
+				result = bootResult{serial: serial, stats: stats, err: err}
+			}
+
+			if result.err != nil {
+				if cause := bootFailureCause(adb, serial); cause != "" {
+					result.err = fmt.Errorf("%w, %s", result.err, cause)
+				}
+			}
+			results[i] = result
+		}(i, serial)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func printBootSummary(results []bootResult) (failed int) {
+	sorted := append([]bootResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].serial < sorted[j].serial })
+
+	logger.Println()
+	logger.Infof("Boot summary:")
+	for _, result := range sorted {
+		if result.err != nil {
+			failed++
+			logger.Errorf("- %s: failed after %d seconds: %s", result.serial, result.stats.Duration/time.Second, result.err)
+		} else {
+			logger.Donef("- %s: booted in %d seconds", result.serial, result.stats.Duration/time.Second)
+		}
+	}
+	logger.Printf("%d/%d devices ready", len(sorted)-failed, len(sorted))
+
+	return failed
+}
+
+// bootFailureCause tells apart a dead emulator process, an emulator adb can't see and a guest that is still
+// booting, based on the emulator console and the adb server.
+func bootFailureCause(adb *adbmanager.Model, serial string) string {
+	if _, err := emuconsole.PortFromSerial(serial); err != nil {
+		return ""
+	}
+
+	ctx, cancel := cleanupContext(bootFailureCauseTimeout)
+	defer cancel()
+
+	status, err := emuconsole.Probe(ctx, serial)
+	if err != nil {
+		logger.Warnf("Failed to query the emulator console of %s: %s", serial, err)
+		return ""
+	}
+	if !status.Running {
+		return "cause: the emulator process is not running (console port is closed)"
+	}
+
+	state, err := adb.DeviceState(ctx, serial)
+	if err != nil {
+		return fmt.Sprintf("cause: the emulator (%s) is running but adb can't see it: %s", status.AVDName, err)
+	}
+	if state != "device" {
+		return fmt.Sprintf("cause: the emulator (%s) is running but adb sees it as %s", status.AVDName, state)
+	}
+	return fmt.Sprintf("cause: the emulator (%s, %s) is running and online, but the guest OS is still booting", status.AVDName, status.AVDStatus)
+}
@@ -0,0 +1,187 @@
+package diagnostics
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-io/go-utils/v2/log"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+// ArchiveName is the file name of the diagnostics bundle.
+const ArchiveName = "emulator-diagnostics.zip"
+
+// itemTimeout limits each collected item, a wedged device or adb server must not block the step from failing.
+const itemTimeout = 30 * time.Second
+
+// dumpsysExcerptLines is the number of lines kept of the (potentially huge) dumpsys outputs.
+const dumpsysExcerptLines = 300
+
+type item struct {
+	name    string
+	collect func(ctx context.Context) ([]byte, error)
+}
+
+// Collector gathers the state of the host, the adb server and the devices for debugging failed boots.
+type Collector struct {
+	adb        *adbmanager.Model
+	cmdFactory adbmanager.CommandFactory
+	logger     log.Logger
+}
+
+// NewCollector ...
+func NewCollector(adb *adbmanager.Model, cmdFactory adbmanager.CommandFactory, logger log.Logger) *Collector {
+	return &Collector{
+		adb:        adb,
+		cmdFactory: cmdFactory,
+		logger:     logger,
+	}
+}
+
+// Collect gathers the diagnostics of the given devices into a zip archive at pth.
+// Items that can't be collected are listed in errors.txt of the archive instead of failing the whole collection.
+func (c Collector) Collect(ctx context.Context, serials []string, pth string) error {
+	items := []item{
+		{name: "adb-devices.txt", collect: c.adbDevices},
+		{name: "host-loadavg.txt", collect: readFile("/proc/loadavg")},
+		{name: "host-meminfo.txt", collect: readFile("/proc/meminfo")},
+		{name: "host-emulator-processes.txt", collect: c.emulatorProcesses},
+	}
+	for _, serial := range serials {
+		prefix := strings.ReplaceAll(serial, ":", "_") + "/"
+		items = append(items,
+			item{name: prefix + "getprop.txt", collect: c.shell(serial, "getprop")},
+			item{name: prefix + "screenshot.png", collect: c.screenshot(serial)},
+			item{name: prefix + "dumpsys-activity.txt", collect: excerpt(c.shell(serial, "dumpsys", "activity"))},
+			item{name: prefix + "dumpsys-window.txt", collect: excerpt(c.shell(serial, "dumpsys", "window"))},
+		)
+	}
+
+	file, err := os.Create(pth)
+	if err != nil {
+		return err
+	}
+
+	archive := zip.NewWriter(file)
+	var collectErrors []string
+	for _, item := range items {
+		c.logger.Printf("Collecting %s", item.name)
+
+		itemCtx, cancel := context.WithTimeout(ctx, itemTimeout)
+		content, err := item.collect(itemCtx)
+		cancel()
+		if err != nil {
+			collectErrors = append(collectErrors, fmt.Sprintf("%s: %s", item.name, err))
+			if len(content) == 0 {
+				continue
+			}
+		}
+
+		if err := writeEntry(archive, item.name, content); err != nil {
+			return closeWithError(archive, file, err)
+		}
+	}
+
+	if len(collectErrors) > 0 {
+		if err := writeEntry(archive, "errors.txt", []byte(strings.Join(collectErrors, "\n"))); err != nil {
+			return closeWithError(archive, file, err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return closeWithError(nil, file, err)
+	}
+	return file.Close()
+}
+
+func (c Collector) adbDevices(ctx context.Context) ([]byte, error) {
+	return run(c.adb.DevicesCmd(ctx, nil))
+}
+
+func (c Collector) emulatorProcesses(ctx context.Context) ([]byte, error) {
+	out, err := run(c.cmdFactory.Create(ctx, "ps", []string{"-axo", "pid,command"}, nil))
+	if err != nil {
+		return out, err
+	}
+
+	// Example line:
+	// 1234 /opt/android-sdk/emulator/qemu/linux-x86_64/qemu-system-x86_64 -avd emulator -no-window
+	var lines []string
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		binary := filepath.Base(fields[1])
+		if strings.HasPrefix(binary, "qemu-system") || strings.HasPrefix(binary, "emulator") {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+func (c Collector) shell(serial string, commands ...string) func(ctx context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		return run(c.adb.ShellCmd(ctx, serial, nil, commands...))
+	}
+}
+
+func (c Collector) screenshot(serial string) func(ctx context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		var stdout, stderr bytes.Buffer
+		cmd := c.adb.ExecOutCmd(ctx, serial, &command.Opts{Stdout: &stdout, Stderr: &stderr}, "screencap", "-p")
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		return stdout.Bytes(), nil
+	}
+}
+
+func readFile(pth string) func(ctx context.Context) ([]byte, error) {
+	return func(_ context.Context) ([]byte, error) {
+		return os.ReadFile(pth)
+	}
+}
+
+// excerpt keeps the first dumpsysExcerptLines lines of the collected content.
+func excerpt(collect func(ctx context.Context) ([]byte, error)) func(ctx context.Context) ([]byte, error) {
+	return func(ctx context.Context) ([]byte, error) {
+		content, err := collect(ctx)
+		lines := strings.SplitAfter(string(content), "\n")
+		if len(lines) > dumpsysExcerptLines {
+			lines = append(lines[:dumpsysExcerptLines], fmt.Sprintf("... (%d more lines)\n", len(lines)-dumpsysExcerptLines))
+		}
+		return []byte(strings.Join(lines, "")), err
+	}
+}
+
+func run(cmd command.Command) ([]byte, error) {
+	out, err := cmd.RunAndReturnTrimmedCombinedOutput()
+	return []byte(out), err
+}
+
+func writeEntry(archive *zip.Writer, name string, content []byte) error {
+	writer, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+func closeWithError(archive *zip.Writer, file *os.File, err error) error {
+	if archive != nil {
+		// The original error is more relevant than the one of closing the incomplete archive.
+		_ = archive.Close()
+	}
+	_ = file.Close()
+	return err
+}
@@ -0,0 +1,280 @@
+// Package emuconsole is a client for the Android emulator console, reachable on localhost at the port
+// in the emulator's serial (emulator-5554 listens on 5554).
+// See: https://developer.android.com/studio/run/emulator-console
+package emuconsole
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const serialPrefix = "emulator-"
+
+// Console port range of local emulators, each emulator takes the first free even port as its console port.
+const (
+	firstConsolePort = 5554
+	lastConsolePort  = 5682
+)
+
+// bannerTimeout limits waiting for the console banner while scanning the console ports.
+const bannerTimeout = 2 * time.Second
+
+// probeTimeout limits the whole console session of Probe.
+const probeTimeout = 10 * time.Second
+
+// authTokenFileName is the file the emulator generates the console auth token into, in the user's home.
+const authTokenFileName = ".emulator_console_auth_token"
+
+// ErrConsoleClosed is returned if nothing listens on the console port, which means the emulator process is not running.
+var ErrConsoleClosed = errors.New("emulator console port is closed")
+
+// CommandError is a KO response of the console.
+type CommandError struct {
+	Command string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("emulator console command (%s) failed: %s", e.Command, e.Message)
+}
+
+// PortFromSerial returns the console port of an emulator serial like emulator-5554.
+func PortFromSerial(serial string) (int, error) {
+	if !strings.HasPrefix(serial, serialPrefix) {
+		return 0, fmt.Errorf("serial (%s) is not an emulator serial", serial)
+	}
+
+	port, err := strconv.Atoi(strings.TrimPrefix(serial, serialPrefix))
+	if err != nil {
+		return 0, fmt.Errorf("serial (%s) is not an emulator serial: %w", serial, err)
+	}
+	return port, nil
+}
+
+// SerialFromPort returns the serial of the emulator listening on the given console port.
+func SerialFromPort(port int) string {
+	return serialPrefix + strconv.Itoa(port)
+}
+
+// RunningSerials scans the console ports of local emulators and returns the serials of those that reply
+// with a console banner.
+func RunningSerials(ctx context.Context) []string {
+	var serials []string
+	for port := firstConsolePort; port <= lastConsolePort; port += 2 {
+		if ctx.Err() != nil {
+			break
+		}
+		if isConsole(ctx, port) {
+			serials = append(serials, SerialFromPort(port))
+		}
+	}
+	return serials
+}
+
+func isConsole(ctx context.Context, port int) bool {
+	ctx, cancel := context.WithTimeout(ctx, bannerTimeout)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return false
+	}
+	// Example banner: Android Console: type 'help' for a list of commands
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	return err == nil && strings.HasPrefix(line, "Android Console")
+}
+
+// Console is an authenticated connection to an emulator console.
+type Console struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to the console and authenticates with the token of the current user, if the console requires it.
+// The context's deadline applies to the whole lifetime of the connection.
+func Dial(ctx context.Context, port int) (*Console, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("%w: %s", ErrConsoleClosed, err)
+		}
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	console := &Console{conn: conn, reader: bufio.NewReader(conn)}
+	banner, err := console.readReply("banner")
+	if err != nil {
+		_ = console.Close()
+		return nil, err
+	}
+
+	if strings.Contains(banner, "Authentication required") {
+		token, err := readAuthToken()
+		if err != nil {
+			_ = console.Close()
+			return nil, err
+		}
+		if _, err := console.Run("auth " + token); err != nil {
+			_ = console.Close()
+			return nil, err
+		}
+	}
+
+	return console, nil
+}
+
+// Close ...
+func (c *Console) Close() error {
+	_, _ = fmt.Fprint(c.conn, "quit\r\n")
+	return c.conn.Close()
+}
+
+// Run sends a command and returns its output without the closing OK line.
+func (c *Console) Run(command string) (string, error) {
+	if _, err := fmt.Fprintf(c.conn, "%s\r\n", command); err != nil {
+		return "", err
+	}
+
+	name := command
+	if strings.HasPrefix(command, "auth ") {
+		// Keep the token out of the logs.
+		name = "auth"
+	}
+	return c.readReply(name)
+}
+
+// Ping checks if the console is responsive.
+func (c *Console) Ping() error {
+	_, err := c.Run("ping")
+	return err
+}
+
+// AVDName returns the name of the virtual device.
+func (c *Console) AVDName() (string, error) {
+	return c.Run("avd name")
+}
+
+// AVDStatus returns the state of the virtual device, e.g. "virtual device is running".
+func (c *Console) AVDStatus() (string, error) {
+	return c.Run("avd status")
+}
+
+// Kill terminates the emulator.
+func (c *Console) Kill() error {
+	if _, err := fmt.Fprint(c.conn, "kill\r\n"); err != nil {
+		return err
+	}
+
+	// Example reply: "OK: killing emulator, bye bye"
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, "OK") {
+		return &CommandError{Command: "kill", Message: strings.TrimSpace(line)}
+	}
+	return nil
+}
+
+// readReply reads lines until the closing OK or KO line.
+func (c *Console) readReply(command string) (string, error) {
+	var lines []string
+	for {
+		line, err := c.reader.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("read reply of %s: %w", command, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch {
+		case line == "OK":
+			return strings.Join(lines, "\n"), nil
+		case strings.HasPrefix(line, "KO"):
+			return "", &CommandError{Command: command, Message: strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "KO"), ":"))}
+		default:
+			lines = append(lines, line)
+		}
+	}
+}
+
+func readAuthToken() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	pth := filepath.Join(home, authTokenFileName)
+	token, err := os.ReadFile(pth)
+	if err != nil {
+		return "", fmt.Errorf("read console auth token: %w", err)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// Status is the state of an emulator as seen through its console.
+type Status struct {
+	// Running is false if the console port is closed.
+	Running bool
+	AVDName string
+	// AVDStatus is the reply of `avd status`, e.g. "virtual device is running".
+	AVDStatus string
+}
+
+// Probe connects to the console of the emulator with the given serial and queries its state.
+func Probe(ctx context.Context, serial string) (Status, error) {
+	port, err := PortFromSerial(serial)
+	if err != nil {
+		return Status{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	console, err := Dial(ctx, port)
+	if errors.Is(err, ErrConsoleClosed) {
+		return Status{Running: false}, nil
+	} else if err != nil {
+		return Status{}, err
+	}
+	defer func() {
+		_ = console.Close()
+	}()
+
+	if err := console.Ping(); err != nil {
+		return Status{}, err
+	}
+
+	status := Status{Running: true}
+	if status.AVDName, err = console.AVDName(); err != nil {
+		return status, err
+	}
+	if status.AVDStatus, err = console.AVDStatus(); err != nil {
+		return status, err
+	}
+	return status, nil
+}
@@ -0,0 +1,259 @@
+// Package emulator finds, kills and relaunches emulator processes on Linux hosts, based on /proc.
+package emulator
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/emuconsole"
+)
+
+const procDir = "/proc"
+
+// defaultConsolePort is used by the emulator if neither -port nor -ports is set.
+const defaultConsolePort = 5554
+
+const (
+	exitTimeout      = 30 * time.Second
+	exitPollInterval = 500 * time.Millisecond
+)
+
+// Process is a running emulator process with the command line and environment it was started with.
+type Process struct {
+	PID  int
+	Args []string
+	Env  []string
+	Dir  string
+}
+
+// FindProcess returns the emulator process serving the given serial. The `emulator` launcher process is preferred
+// over the `qemu-system-*` process it starts, as relaunching the launcher sets up the environment of qemu as well.
+func FindProcess(serial string) (*Process, error) {
+	port, err := emuconsole.PortFromSerial(serial)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(procDir)
+	if err != nil {
+		return nil, fmt.Errorf("list processes: %w", err)
+	}
+
+	var qemuProcess *Process
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		args, err := readNullSeparated(filepath.Join(procDir, entry.Name(), "cmdline"))
+		if err != nil || len(args) == 0 || consolePort(args) != port {
+			continue
+		}
+
+		binary := filepath.Base(args[0])
+		isLauncher := strings.HasPrefix(binary, "emulator")
+		if !isLauncher && !strings.HasPrefix(binary, "qemu-system") {
+			continue
+		}
+
+		process := &Process{PID: pid, Args: args}
+		// The environment and the working directory are best effort, the command line is enough to relaunch.
+		process.Env, _ = readNullSeparated(filepath.Join(procDir, entry.Name(), "environ"))
+		process.Dir, _ = os.Readlink(filepath.Join(procDir, entry.Name(), "cwd"))
+
+		if isLauncher {
+			return process, nil
+		}
+		qemuProcess = process
+	}
+
+	if qemuProcess == nil {
+		return nil, fmt.Errorf("no emulator process found for %s", serial)
+	}
+	return qemuProcess, nil
+}
+
+// Kill terminates the emulator through its console, then kills the process if it doesn't exit in time.
+func Kill(ctx context.Context, serial string, process *Process) error {
+	if err := killThroughConsole(ctx, serial); err != nil {
+		if err := syscall.Kill(process.PID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("kill emulator process (%d): %w", process.PID, err)
+		}
+	}
+
+	if waitForExit(ctx, process.PID) {
+		return nil
+	}
+
+	if err := syscall.Kill(process.PID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("kill emulator process (%d): %w", process.PID, err)
+	}
+	if !waitForExit(ctx, process.PID) {
+		return fmt.Errorf("emulator process (%d) did not exit", process.PID)
+	}
+	return nil
+}
+
+// Launch starts the emulator again with its original command line and the extra flags that are not set yet.
+// The new process is detached from the step, its output is written to logPth.
+func Launch(process *Process, extraFlags []string, logPth string) (*Process, error) {
+	args := append([]string{}, process.Args...)
+	for _, flag := range extraFlags {
+		if !contains(args, flag) {
+			args = append(args, flag)
+		}
+	}
+
+	logFile, err := os.Create(logPth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		// The child process keeps its own descriptor open.
+		_ = logFile.Close()
+	}()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = process.Dir
+	if len(process.Env) > 0 {
+		cmd.Env = process.Env
+	}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// The emulator has to outlive the step.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	relaunched := &Process{PID: cmd.Process.Pid, Args: args, Env: process.Env, Dir: process.Dir}
+	if err := cmd.Process.Release(); err != nil {
+		return nil, err
+	}
+	return relaunched, nil
+}
+
+func killThroughConsole(ctx context.Context, serial string) error {
+	port, err := emuconsole.PortFromSerial(serial)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exitTimeout)
+	defer cancel()
+
+	console, err := emuconsole.Dial(ctx, port)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = console.Close()
+	}()
+
+	return console.Kill()
+}
+
+// waitForExit returns true if the process exited within exitTimeout.
+func waitForExit(ctx context.Context, pid int) bool {
+	ctx, cancel := context.WithTimeout(ctx, exitTimeout)
+	defer cancel()
+
+	for {
+		if !isRunning(pid) {
+			return true
+		}
+
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(exitPollInterval):
+		}
+	}
+}
+
+// isRunning tells if the process exists and it is not a zombie. A relaunched emulator is a child of the step,
+// so it is reaped here if it already exited.
+func isRunning(pid int) bool {
+	var status syscall.WaitStatus
+	// ECHILD is returned for processes that are not children of the step, that is expected.
+	_, _ = syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+
+	stat, err := os.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+
+	// Example: 1234 (qemu-system-x86) S 1 ...
+	idx := bytes.LastIndexByte(stat, ')')
+	if idx == -1 || idx+2 >= len(stat) {
+		return true
+	}
+	state := stat[idx+2]
+	return state != 'Z' && state != 'X'
+}
+
+// AVDName returns the name of the AVD the process runs, from the -avd or @name argument.
+func (p Process) AVDName() string {
+	for i, arg := range p.Args[1:] {
+		if arg == "-avd" && i+2 < len(p.Args) {
+			return p.Args[i+2]
+		}
+		if name, ok := strings.CutPrefix(arg, "@"); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// consolePort returns the console port from the -port or -ports flags of the emulator command line.
+func consolePort(args []string) int {
+	for i := 0; i < len(args)-1; i++ {
+		switch args[i] {
+		case "-port":
+			if port, err := strconv.Atoi(args[i+1]); err == nil {
+				return port
+			}
+		case "-ports":
+			consolePortArg, _, _ := strings.Cut(args[i+1], ",")
+			if port, err := strconv.Atoi(consolePortArg); err == nil {
+				return port
+			}
+		}
+	}
+	return defaultConsolePort
+}
+
+func readNullSeparated(pth string) ([]string, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, value := range bytes.Split(bytes.TrimRight(content, "\x00"), []byte{0}) {
+		if len(value) > 0 {
+			values = append(values, string(value))
+		}
+	}
+	return values, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -5,11 +5,11 @@ go 1.21
 require (
 	github.com/bitrise-io/go-android/v2 v2.0.0-alpha.10
 	github.com/bitrise-io/go-steputils/v2 v2.0.0-alpha.6
+	github.com/bitrise-io/go-utils v1.0.13
 	github.com/bitrise-io/go-utils/v2 v2.0.0-alpha.23
+	github.com/hashicorp/go-version v1.7.0
 )
 
 require (
-	github.com/bitrise-io/go-utils v1.0.13 // indirect
-	github.com/hashicorp/go-version v1.7.0 // indirect
 	golang.org/x/sys v0.22.0 // indirect
 )
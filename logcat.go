@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+const (
+	logcatCaptureNever     = "never"
+	logcatCaptureOnFailure = "on_failure"
+	logcatCaptureAlways    = "always"
+
+	logcatRestartInterval = 2 * time.Second
+)
+
+// logcatTimestampPattern matches the timestamp at the start of a `threadtime` logcat line.
+var logcatTimestampPattern = regexp.MustCompile(`^\d\d-\d\d \d\d:\d\d:\d\d\.\d{3}`)
+
+// relevantLogcatLinePattern matches crashes and errors of the system processes in the `threadtime` logcat format:
+// 10-17 12:00:00.000  1234  1234 E AndroidRuntime: FATAL EXCEPTION: main
+var relevantLogcatLinePattern = regexp.MustCompile(`\s[EF]\s+(AndroidRuntime|SystemServer|system_server|ActivityManager|DEBUG|libc|init)\s*:|FATAL EXCEPTION|ANR in `)
+
+type logcatRecorder struct {
+	serial string
+	pth    string
+	file   *os.File
+	output *timestampWriter
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// timestampWriter passes the logcat output through, remembering the timestamp of the last complete line.
+// It is only read after the logcat process exited, so the last timestamp needs no locking.
+type timestampWriter struct {
+	w       io.Writer
+	partial []byte
+	last    string
+}
+
+func (w *timestampWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx == -1 {
+			break
+		}
+		if timestamp := logcatTimestampPattern.Find(w.partial[:idx]); timestamp != nil {
+			w.last = string(timestamp)
+		}
+		w.partial = w.partial[idx+1:]
+	}
+	return w.w.Write(p)
+}
+
+func startLogcatRecorder(ctx context.Context, adb *adbmanager.Model, serial, dir string) (*logcatRecorder, error) {
+	pth := filepath.Join(dir, fmt.Sprintf("logcat-%s.txt", strings.ReplaceAll(serial, ":", "_")))
+	file, err := os.Create(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &timestampWriter{w: file}
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := adb.LogcatCmd(ctx, serial, "", &command.Opts{Stdout: output, Stderr: file})
+	if err := cmd.Start(); err != nil {
+		cancel()
+		if err := file.Close(); err != nil {
+			logger.Warnf("Failed to close %s: %s", pth, err)
+		}
+		return nil, err
+	}
+
+	r := &logcatRecorder{serial: serial, pth: pth, file: file, output: output, cancel: cancel, done: make(chan struct{})}
+	go r.supervise(ctx, adb, cmd)
+	return r, nil
+}
+
+// supervise restarts the logcat process until the recorder is stopped, as logcat exits whenever the device
+// disconnects (e.g. on adb server restarts or emulator recovery). The restarted logcat appends to the same file,
+// starting from the last captured line, so the log buffer of the device is not captured again.
+func (r *logcatRecorder) supervise(ctx context.Context, adb *adbmanager.Model, cmd command.Command) {
+	defer close(r.done)
+
+	for {
+		err := cmd.Wait()
+		if ctx.Err() != nil {
+			return
+		}
+		logger.Debugf("Logcat of %s exited (%v), restarting...", r.serial, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(logcatRestartInterval):
+		}
+
+		if _, err := fmt.Fprintf(r.file, "--------- logcat restarted at %s\n", time.Now().Format(time.RFC3339)); err != nil {
+			logger.Warnf("Failed to write %s: %s", r.pth, err)
+		}
+		cmd = adb.LogcatCmd(ctx, r.serial, r.output.last, &command.Opts{Stdout: r.output, Stderr: r.file})
+		if err := cmd.Start(); err != nil {
+			logger.Warnf("Failed to restart logcat capture for %s: %s", r.serial, err)
+			return
+		}
+	}
+}
+
+func (r *logcatRecorder) stop() {
+	r.cancel()
+	// The logcat process is killed by the cancelled context.
+	<-r.done
+	if err := r.file.Close(); err != nil {
+		logger.Warnf("Failed to close %s: %s", r.pth, err)
+	}
+}
+
+func (r *logcatRecorder) remove() {
+	if err := os.Remove(r.pth); err != nil {
+		logger.Warnf("Failed to remove %s: %s", r.pth, err)
+	}
+}
+
+// relevantLines returns the last n crash and system error lines of the captured logcat.
+func (r *logcatRecorder) relevantLines(n int) ([]string, error) {
+	file, err := os.Open(r.pth)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			logger.Warnf("Failed to close %s: %s", r.pth, err)
+		}
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !relevantLogcatLinePattern.MatchString(line) {
+			continue
+		}
+
+		lines = append(lines, line)
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+func startLogcatRecorders(ctx context.Context, adb *adbmanager.Model, serials []string, dir string) []*logcatRecorder {
+	var recorders []*logcatRecorder
+	for _, serial := range serials {
+		recorder, err := startLogcatRecorder(ctx, adb, serial, dir)
+		if err != nil {
+			logger.Warnf("Failed to start logcat capture for %s: %s", serial, err)
+			continue
+		}
+		logger.Printf("Capturing logcat of %s to %s", serial, recorder.pth)
+		recorders = append(recorders, recorder)
+	}
+	return recorders
+}
+
+func stopLogcatRecorders(recorders []*logcatRecorder) {
+	for _, recorder := range recorders {
+		recorder.stop()
+	}
+}
+
+// logcatFailureExcerpt returns the relevant logcat lines of the failed devices.
+func logcatFailureExcerpt(recorders []*logcatRecorder, results []bootResult, n int) string {
+	failed := map[string]bool{}
+	for _, result := range results {
+		failed[result.serial] = result.err != nil
+	}
+
+	var excerpt strings.Builder
+	for _, recorder := range recorders {
+		if !failed[recorder.serial] {
+			continue
+		}
+
+		lines, err := recorder.relevantLines(n)
+		if err != nil {
+			logger.Warnf("Failed to read logcat of %s: %s", recorder.serial, err)
+			continue
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		excerpt.WriteString(fmt.Sprintf("\nLast relevant logcat lines of %s (full log: %s):\n", recorder.serial, recorder.pth))
+		excerpt.WriteString(strings.Join(lines, "\n"))
+	}
+
+	return excerpt.String()
+}
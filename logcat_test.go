@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTimestampWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := &timestampWriter{w: &out}
+
+	chunks := []string{
+		"--------- beginning of main\n",
+		"10-17 12:00:00.000  1234  1234 I ActivityManager: Start proc\n10-17 12:00:01.",
+		"500  1234  1234 E AndroidRuntime: FATAL EXCEPTION: main\n",
+		"10-17 12:00:02.000  1234  1234 I Zygote: partial line without newline",
+	}
+	for _, chunk := range chunks {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if w.last != "10-17 12:00:01.500" {
+		t.Errorf("last = %q, want the timestamp of the last complete line", w.last)
+	}
+	if want := chunks[0] + chunks[1] + chunks[2] + chunks[3]; out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+}
@@ -1,30 +1,104 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/bitrise-io/go-android/v2/adbmanager"
 	"github.com/bitrise-io/go-android/v2/sdk"
 	"github.com/bitrise-io/go-steputils/v2/stepconf"
-	"github.com/bitrise-io/go-utils/v2/command"
 	"github.com/bitrise-io/go-utils/v2/env"
 	"github.com/bitrise-io/go-utils/v2/log"
 	"github.com/bitrise-io/go-utils/v2/system"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbclient"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/diagnostics"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/emulator"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/preflight"
 )
 
 var logger = log.NewLogger()
 
+const (
+	// serverCommandTimeout limits starting and stopping the private adb server.
+	serverCommandTimeout = 30 * time.Second
+	// diagnosticsTimeout limits collecting the diagnostics, on top of the timeout of each collected item.
+	diagnosticsTimeout = 5 * time.Minute
+	// unlockTimeout and settingsTimeout limit the steps after the boot on each device, the boot timeout doesn't cover them.
+	unlockTimeout   = 1 * time.Minute
+	settingsTimeout = 2 * time.Minute
+)
+
+// collectDiagnostics is set once adb is available, failf runs it to bundle the state of the failed boot.
+var collectDiagnostics func()
+
+// failureCleanups are run by failf after collecting the diagnostics, e.g. to disconnect the network devices.
+var failureCleanups []func()
+
 type Inputs struct {
-	EmulatorSerial string `env:"emulator_serial,required"`
-	BootTimeout    int    `env:"boot_timeout,required"`
-	AndroidHome    string `env:"android_home,dir"`
+	EmulatorSerial     string   `env:"emulator_serial"`
+	BootTimeout        int      `env:"boot_timeout,required"`
+	AndroidHome        string   `env:"android_home"`
+	ADBPath            string   `env:"adb_path"`
+	InstallADB         bool     `env:"install_platform_tools,opt[true,false]"`
+	SDKRepository      string   `env:"sdk_repository"`
+	ReadinessProbes    []string `env:"readiness_probes,multiline"`
+	LogcatCapture      string   `env:"logcat_capture,opt[never,on_failure,always]"`
+	LogcatFailureLines int      `env:"logcat_failure_lines,range[0..1000]"`
+	DeployDir          string   `env:"deploy_dir"`
+	LockedScreen       string   `env:"locked_screen,opt[warn,fail]"`
+	SettingsProfile    string   `env:"settings_profile,opt[none,testing]"`
+	ExtraSettings      []string `env:"extra_settings,multiline"`
+	ADBClient          string   `env:"adb_client,opt[native,binary]"`
+	BootRecovery       bool     `env:"boot_recovery,opt[true,false]"`
+	BootStallTimeout   int      `env:"boot_stall_timeout,range[0..3600]"`
+	RecoveryAttempts   int      `env:"boot_recovery_attempts,range[1..10]"`
+	RecoveryFlags      string   `env:"boot_recovery_flags"`
+	PollStrategy       string   `env:"poll_strategy,opt[fixed,exponential]"`
+	PollInterval       int      `env:"poll_interval,range[1..600]"`
+	PollMaxInterval    int      `env:"poll_max_interval,range[1..600]"`
+	PollJitter         int      `env:"poll_jitter,range[0..100]"`
+	AttemptTimeout     int      `env:"attempt_timeout,range[0..3600]"`
+	DeviceGracePeriod  int      `env:"device_grace_period,range[0..3600]"`
+	RequiredServices   []string `env:"required_services,multiline"`
+	RequiredPackages   []string `env:"required_packages,multiline"`
+	MinDataFreeSpace   int      `env:"min_data_free_space,range[0..1048576]"`
+	NetworkCheckTarget string   `env:"network_check_target"`
+	HostPreflight      string   `env:"host_preflight,opt[warn,fail,skip]"`
+	ServerRestartAfter int      `env:"adb_server_restart_after,range[1..100]"`
+	ADBServerPort      int      `env:"adb_server_port,range[0..65535]"`
+	ADBMinVersion      string   `env:"adb_min_version"`
+}
+
+// validate checks the combinations of inputs the input parser can't.
+func (inputs Inputs) validate() error {
+	if inputs.PollStrategy == "exponential" && inputs.PollMaxInterval < inputs.PollInterval {
+		return fmt.Errorf("poll_max_interval (%d) must not be less than poll_interval (%d)", inputs.PollMaxInterval, inputs.PollInterval)
+	}
+	if inputs.BootRecovery && inputs.BootStallTimeout == 0 {
+		return fmt.Errorf("boot_stall_timeout must be greater than 0 if boot_recovery is enabled")
+	}
+	return nil
 }
 
 func failf(format string, v ...interface{}) {
 	logger.Errorf(format, v...)
 
+	if collectDiagnostics != nil {
+		collectDiagnostics()
+	}
+	// In reverse order, e.g. the network devices are disconnected before the adb server is stopped.
+	for i := len(failureCleanups) - 1; i >= 0; i-- {
+		failureCleanups[i]()
+	}
+
 	cpuIsARM, err := system.CPU.IsARM()
 	if err != nil {
 		logger.Errorf("Failed to check CPU: %s", err)
@@ -37,35 +111,421 @@ func failf(format string, v ...interface{}) {
 
 func main() {
 	envRepo := env.NewRepository()
-	cmdFactory := command.NewFactory(env.NewRepository())
+	cmdFactory := adbmanager.NewCommandFactory(env.NewRepository())
 
 	var inputs Inputs
 	if err := stepconf.NewInputParser(envRepo).Parse(&inputs); err != nil {
 		failf("Issue with inputs: %s", err)
 	}
 	stepconf.Print(inputs)
+	if err := inputs.validate(); err != nil {
+		failf("Issue with inputs: %s", err)
+	}
 
-	fmt.Println()
+	probes, err := adbmanager.ReadinessProbesByName(inputs.ReadinessProbes)
+	if err != nil {
+		failf("Issue with inputs: %s", err)
+	}
+	requirements, err := requirementProbes(inputs.RequiredServices, inputs.RequiredPackages)
+	if err != nil {
+		failf("Issue with inputs: %s", err)
+	}
+	probes = append(probes, requirements...)
+	if inputs.MinDataFreeSpace > 0 {
+		probes = withStorageProbe(probes, adbmanager.StorageProbe(inputs.MinDataFreeSpace))
+	}
+	if inputs.NetworkCheckTarget != "" {
+		probe, err := adbmanager.NetworkTargetProbe(inputs.NetworkCheckTarget)
+		if err != nil {
+			failf("Issue with inputs: %s", err)
+		}
+		probes = append(probes, probe)
+	}
 
-	androidSdk, err := sdk.New(inputs.AndroidHome)
+	extraSettings, err := parseSettings(inputs.ExtraSettings)
 	if err != nil {
-		failf("Failed to create Android SDK: %s", err)
+		failf("Issue with inputs: %s", err)
+	}
+
+	fmt.Println()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var androidSdk *sdk.Model
+	var androidHome string
+	if inputs.AndroidHome != "" {
+		if androidSdk, err = sdk.New(inputs.AndroidHome); err != nil {
+			logger.Warnf("Invalid Android SDK: %s", err)
+		} else {
+			androidHome = androidSdk.GetAndroidHome()
+		}
+	}
+
+	logger.Infof("Locating adb...")
+	adbPth, err := adbmanager.ResolveBinary(adbCandidates(inputs.ADBPath, androidHome, envRepo), logger)
+	if err != nil && inputs.InstallADB && inputs.ADBPath == "" {
+		logger.Warnf("%s", err)
+		if androidSdk == nil {
+			failf("Failed to install platform-tools: a valid Android SDK path is required")
+		}
+		if adbPth, err = installPlatformTools(ctx, androidSdk, cmdFactory, inputs.SDKRepository); err != nil {
+			failf("Failed to install platform-tools: %s", err)
+		}
+	} else if err != nil {
+		failf("Failed to locate adb: %s", err)
 	}
 
-	adb, err := adbmanager.New(androidSdk, cmdFactory, logger)
+	adb, err := adbmanager.New(adbPth, cmdFactory, logger, inputs.ADBMinVersion)
 	if err != nil {
 		failf("Failed to create ADB model: %s", err)
 	}
 
-	if err := adb.WaitForDevice(inputs.EmulatorSerial, time.Duration(inputs.BootTimeout)*time.Second); err != nil {
-		failf(err.Error())
+	if inputs.ADBServerPort != 0 {
+		adb.SetServerPort(inputs.ADBServerPort)
+	}
+
+	if inputs.ADBClient == "native" {
+		port := inputs.ADBServerPort
+		if port == 0 {
+			if port, err = adbServerPort(envRepo); err != nil {
+				failf("Invalid adb server port: %s", err)
+			}
+		}
+		adb.SetClient(adbclient.New(port))
+	}
+
+	var serials []string
+	if inputs.DeployDir != "" {
+		collector := diagnostics.NewCollector(adb, cmdFactory, logger)
+		collectDiagnostics = func() {
+			logger.Println()
+			logger.Infof("Collecting diagnostics...")
+			pth := filepath.Join(inputs.DeployDir, diagnostics.ArchiveName)
+			ctx, cancel := cleanupContext(diagnosticsTimeout)
+			defer cancel()
+			if err := collector.Collect(ctx, serials, pth); err != nil {
+				logger.Warnf("Failed to collect diagnostics: %s", err)
+				return
+			}
+			logger.Printf("Diagnostics saved to %s", pth)
+		}
+	}
+
+	if inputs.ADBServerPort != 0 {
+		if err := startPrivateServer(ctx, adb, inputs.ADBServerPort); err != nil {
+			failf("Failed to start the adb server on port %d: %s", inputs.ADBServerPort, err)
+		}
+		failureCleanups = append(failureCleanups, func() {
+			stopPrivateServer(adb, inputs.ADBServerPort)
+		})
+	}
+
+	if inputs.HostPreflight != "skip" {
+		checkHost(inputs.HostPreflight == "fail")
+	}
+
+	// Detecting the serials, connecting the network devices and the boot wait share the boot timeout.
+	bootCtx, cancelBoot := context.WithTimeout(ctx, time.Duration(inputs.BootTimeout)*time.Second)
+	defer cancelBoot()
+
+	serials, err = resolveSerials(bootCtx, inputs.EmulatorSerial, adb, detectSerialTimeout(inputs))
+	if err != nil {
+		failf("Failed to determine emulator serials: %s", err)
+	}
+
+	// The network devices are kept connected on success, as the following steps use them.
+	connected, err := connectNetworkDevices(bootCtx, adb, serials)
+	if len(connected) > 0 {
+		failureCleanups = append(failureCleanups, func() {
+			disconnectNetworkDevices(adb, connected)
+		})
+	}
+	if err != nil {
+		failf("Failed to connect to network device: %s", err)
+	}
+
+	if inputs.HostPreflight != "skip" {
+		checkAVDResources(serials, inputs.HostPreflight == "fail")
+	}
+
+	var recorders []*logcatRecorder
+	if inputs.LogcatCapture != logcatCaptureNever {
+		if inputs.DeployDir == "" {
+			logger.Warnf("Deploy dir is not set, logcat is not captured")
+		} else {
+			recorders = startLogcatRecorders(ctx, adb, serials, inputs.DeployDir)
+			failureCleanups = append(failureCleanups, func() {
+				stopLogcatRecorders(recorders)
+			})
+		}
+	}
+
+	waitOpts := adbmanager.WaitOptions{
+		Probes:                 probes,
+		PollStrategy:           pollStrategy(inputs),
+		AttemptTimeout:         time.Duration(inputs.AttemptTimeout) * time.Second,
+		DeviceGracePeriod:      time.Duration(inputs.DeviceGracePeriod) * time.Second,
+		ServerRestartThreshold: inputs.ServerRestartAfter,
+	}
+	var recovery *emulatorRecovery
+	if inputs.BootRecovery {
+		waitOpts.StallTimeout = time.Duration(inputs.BootStallTimeout) * time.Second
+		recovery = newEmulatorRecovery(inputs.RecoveryAttempts, inputs.RecoveryFlags, inputs.DeployDir)
+	}
+
+	results := waitForDevices(bootCtx, adb, serials, waitOpts, recovery)
+
+	if failed := printBootSummary(results); failed > 0 {
+		printTimelines(results)
+		if inputs.DeployDir != "" {
+			if pth, err := saveTimelines(results, inputs.DeployDir); err != nil {
+				logger.Warnf("Failed to save boot timeline: %s", err)
+			} else {
+				logger.Printf("Boot timeline saved to %s", pth)
+			}
+		}
+
+		excerpt := logcatFailureExcerpt(recorders, results, inputs.LogcatFailureLines)
+		failf("%d of %d devices failed to boot%s", failed, len(results), excerpt)
+	}
+
+	for i, serial := range serials {
+		logger.Println()
+		logger.Printf("Unlocking device (%s)...", serial)
+		unlockCtx, cancel := context.WithTimeout(ctx, unlockTimeout)
+		err := adb.UnlockDevice(unlockCtx, serial)
+		cancel()
+		if errors.Is(err, adbmanager.ErrDeviceLocked) {
+			if inputs.LockedScreen == "fail" {
+				failf("Device (%s) is still locked: %s", serial, err)
+			}
+			logger.Warnf("Device (%s) is still locked: %s", serial, err)
+			logger.Warnf("Tests interacting with the UI might fail, consider disabling the lock screen in the emulator image.")
+		} else if err != nil {
+			failf("Failed to unlock device (%s): %s", serial, err)
+		} else {
+			results[i].stats.Timeline.Mark(adbmanager.PhaseUnlocked)
+			logger.Donef("Device (%s) is unlocked", serial)
+		}
+	}
+
+	printTimelines(results)
+
+	if settings := settingsToApply(inputs.SettingsProfile, extraSettings); len(settings) > 0 {
+		for _, serial := range serials {
+			logger.Println()
+			logger.Infof("Applying settings on device (%s)...", serial)
+			settingsCtx, cancel := context.WithTimeout(ctx, settingsTimeout)
+			failures := applySettings(settingsCtx, adb, serial, settings)
+			cancel()
+			if len(failures) > 0 {
+				logger.Warnf("Failed to apply %d of %d settings:", len(failures), len(settings))
+				for _, failure := range failures {
+					logger.Warnf("- %s", failure)
+				}
+			}
+		}
 	}
 
 	logger.Println()
-	logger.Printf("Unlocking device...")
-	if err := adb.UnlockDevice(inputs.EmulatorSerial); err != nil {
-		failf("UnlockDevice command failed: %s", err)
+	logger.Infof("Exporting outputs...")
+	if err := exportOutputs(ctx, cmdFactory, adb, results); err != nil {
+		failf("Failed to export outputs: %s", err)
+	}
+	if inputs.DeployDir != "" {
+		pth, err := saveTimelines(results, inputs.DeployDir)
+		if err != nil {
+			failf("Failed to save boot timeline: %s", err)
+		}
+		if err := exportEnv(ctx, cmdFactory, timelineOutputKey, pth); err != nil {
+			failf("Failed to export %s: %s", timelineOutputKey, err)
+		}
+		logger.Printf("%s=%s", timelineOutputKey, pth)
+	}
+
+	// The logcat is captured until the end of the step, so it covers the unlock and the settings as well.
+	stopLogcatRecorders(recorders)
+	if inputs.LogcatCapture != logcatCaptureAlways {
+		for _, recorder := range recorders {
+			recorder.remove()
+		}
+	}
+
+	if inputs.ADBServerPort != 0 {
+		stopPrivateServer(adb, inputs.ADBServerPort)
 	}
 
+	logger.Println()
 	logger.Donef("Device is ready")
 }
+
+// checkHost runs the host virtualization checks, these don't depend on the emulators.
+func checkHost(failOnProblem bool) {
+	logger.Println()
+	logger.Infof("Checking the host...")
+
+	if problems := preflight.CheckHost(); len(problems) > 0 {
+		reportHostProblems(problems, failOnProblem)
+		return
+	}
+	logger.Donef("Host checks passed")
+}
+
+// checkAVDResources compares the host resources with the config of the AVDs run by the emulators.
+func checkAVDResources(serials []string, failOnProblem bool) {
+	var avds []preflight.AVDConfig
+	for _, serial := range serials {
+		process, err := emulator.FindProcess(serial)
+		if err != nil {
+			logger.Debugf("AVD of %s is not checked: %s", serial, err)
+			continue
+		}
+		name := process.AVDName()
+		if name == "" {
+			continue
+		}
+		avd, err := preflight.ReadAVDConfig(name)
+		if err != nil {
+			logger.Warnf("Failed to read the AVD config of %s: %s", serial, err)
+			continue
+		}
+		avds = append(avds, avd)
+	}
+	if len(avds) == 0 {
+		return
+	}
+
+	if problems := preflight.CheckResources(avds); len(problems) > 0 {
+		logger.Println()
+		reportHostProblems(problems, failOnProblem)
+	}
+}
+
+// reportHostProblems logs the problems, failing the step if requested and a problem is not advisory.
+func reportHostProblems(problems []preflight.Problem, failOnProblem bool) {
+	failed := 0
+	for _, problem := range problems {
+		logger.Warnf("- %s", problem)
+		if !problem.Advisory {
+			failed++
+		}
+	}
+	if failOnProblem && failed > 0 {
+		failf("%d host checks failed", failed)
+	}
+	logger.Warnf("The emulator might fail to boot within the timeout or run very slowly.")
+}
+
+// startPrivateServer starts an adb server on the given port for the step's own use, so other tools restarting
+// the default adb server don't interrupt the boot checks. The server finds the running emulators on its own.
+func startPrivateServer(ctx context.Context, adb *adbmanager.Model, port int) error {
+	logger.Printf("Starting adb server on port %d...", port)
+
+	ctx, cancel := context.WithTimeout(ctx, serverCommandTimeout)
+	defer cancel()
+
+	if out, err := adb.StartServerCmd(ctx, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return nil
+}
+
+func stopPrivateServer(adb *adbmanager.Model, port int) {
+	ctx, cancel := cleanupContext(serverCommandTimeout)
+	defer cancel()
+
+	if out, err := adb.KillServerCmd(ctx, nil).RunAndReturnTrimmedCombinedOutput(); err != nil {
+		logger.Warnf("Failed to stop the adb server on port %d: %s: %s", port, err, out)
+		return
+	}
+	logger.Printf("Stopped the adb server on port %d", port)
+}
+
+// cleanupContext returns the context of the commands run after the boot wait finished or failed. These don't derive
+// from the step's context, as it is already done when the boot wait timed out or the step got interrupted.
+func cleanupContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), timeout)
+}
+
+// adbCandidates returns the locations to look for adb in, in order of precedence. A pinned path is the only candidate.
+func adbCandidates(pinnedPth, androidHome string, envRepo env.Repository) []adbmanager.BinaryCandidate {
+	if pinnedPth != "" {
+		return []adbmanager.BinaryCandidate{{Source: "adb_path input", Path: pinnedPth}}
+	}
+	return []adbmanager.BinaryCandidate{
+		adbmanager.PlatformToolsCandidate("android_home input", androidHome),
+		adbmanager.PlatformToolsCandidate("ANDROID_HOME", envRepo.Get("ANDROID_HOME")),
+		adbmanager.PlatformToolsCandidate("ANDROID_SDK_ROOT", envRepo.Get("ANDROID_SDK_ROOT")),
+		adbmanager.PathCandidate,
+	}
+}
+
+// adbServerPort returns the port of the adb server the adb binary would use as well.
+func adbServerPort(envRepo env.Repository) (int, error) {
+	value := envRepo.Get("ANDROID_ADB_SERVER_PORT")
+	if value == "" {
+		return adbclient.DefaultPort, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// requirementProbes returns the probes checking the required system services and packages.
+func requirementProbes(services, packages []string) ([]adbmanager.ReadinessProbe, error) {
+	var probes []adbmanager.ReadinessProbe
+	for _, service := range services {
+		if service = strings.TrimSpace(service); service != "" {
+			probe, err := adbmanager.ServiceProbe(service)
+			if err != nil {
+				return nil, err
+			}
+			probes = append(probes, probe)
+		}
+	}
+	for _, packageName := range packages {
+		if packageName = strings.TrimSpace(packageName); packageName != "" {
+			probe, err := adbmanager.PackageProbe(packageName)
+			if err != nil {
+				return nil, err
+			}
+			probes = append(probes, probe)
+		}
+	}
+	return probes, nil
+}
+
+// withStorageProbe replaces the storage probe in the list with the given one, or adds it if the list has none.
+func withStorageProbe(probes []adbmanager.ReadinessProbe, storageProbe adbmanager.ReadinessProbe) []adbmanager.ReadinessProbe {
+	for i, probe := range probes {
+		if probe.Name == adbmanager.ProbeStorage {
+			probes[i] = storageProbe
+			return probes
+		}
+	}
+	return append(probes, storageProbe)
+}
+
+// detectSerialTimeout tells how long to wait for an emulator to appear when the serial is not specified.
+func detectSerialTimeout(inputs Inputs) time.Duration {
+	if inputs.DeviceGracePeriod > 0 && inputs.DeviceGracePeriod < inputs.BootTimeout {
+		return time.Duration(inputs.DeviceGracePeriod) * time.Second
+	}
+	return time.Duration(inputs.BootTimeout) * time.Second
+}
+
+func pollStrategy(inputs Inputs) adbmanager.PollStrategy {
+	interval := time.Duration(inputs.PollInterval) * time.Second
+
+	var strategy adbmanager.PollStrategy = adbmanager.FixedPoll{Interval: interval}
+	if inputs.PollStrategy == "exponential" {
+		strategy = adbmanager.ExponentialBackoff{
+			Initial: interval,
+			Max:     time.Duration(inputs.PollMaxInterval) * time.Second,
+		}
+	}
+
+	if inputs.PollJitter > 0 {
+		strategy = adbmanager.Jitter{Strategy: strategy, Fraction: float64(inputs.PollJitter) / 100}
+	}
+	return strategy
+}
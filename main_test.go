@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestInputs_validate(t *testing.T) {
+	valid := Inputs{
+		PollStrategy:     "exponential",
+		PollInterval:     5,
+		PollMaxInterval:  30,
+		BootRecovery:     true,
+		BootStallTimeout: 120,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(inputs *Inputs)
+		wantErr bool
+	}{
+		{name: "valid", modify: func(inputs *Inputs) {}},
+		{name: "max interval less than interval", modify: func(inputs *Inputs) { inputs.PollMaxInterval = 1 }, wantErr: true},
+		{name: "max interval of fixed strategy is ignored", modify: func(inputs *Inputs) {
+			inputs.PollStrategy = "fixed"
+			inputs.PollMaxInterval = 1
+		}},
+		{name: "recovery without stall timeout", modify: func(inputs *Inputs) { inputs.BootStallTimeout = 0 }, wantErr: true},
+		{name: "stall timeout without recovery is ignored", modify: func(inputs *Inputs) {
+			inputs.BootRecovery = false
+			inputs.BootStallTimeout = 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inputs := valid
+			tt.modify(&inputs)
+			if err := inputs.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+const (
+	serialOutputKey         = "BITRISE_EMULATOR_SERIAL"
+	serialsOutputKey        = "BITRISE_EMULATOR_SERIALS"
+	bootDurationOutputKey   = "BITRISE_EMULATOR_BOOT_DURATION"
+	apiLevelOutputKey       = "BITRISE_EMULATOR_API_LEVEL"
+	abiOutputKey            = "BITRISE_EMULATOR_ABI"
+	modelOutputKey          = "BITRISE_EMULATOR_MODEL"
+	bootRetriesOutputKey    = "BITRISE_EMULATOR_BOOT_RETRIES"
+	serverRestartsOutputKey = "BITRISE_EMULATOR_ADB_SERVER_RESTARTS"
+	restartsOutputKey       = "BITRISE_EMULATOR_RESTARTS"
+	timelineOutputKey       = "BITRISE_EMULATOR_BOOT_TIMELINE_PATH"
+)
+
+// deviceInfoTimeout limits reading the properties of a device for the outputs.
+const deviceInfoTimeout = 30 * time.Second
+
+type deviceInfo struct {
+	apiLevel string
+	abi      string
+	model    string
+}
+
+func getDeviceInfo(ctx context.Context, adb *adbmanager.Model, serial string) (deviceInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, deviceInfoTimeout)
+	defer cancel()
+
+	var info deviceInfo
+	for prop, value := range map[string]*string{
+		"ro.build.version.sdk": &info.apiLevel,
+		"ro.product.cpu.abi":   &info.abi,
+		"ro.product.model":     &info.model,
+	} {
+		out, err := adb.GetProp(ctx, serial, prop)
+		if err != nil {
+			return deviceInfo{}, fmt.Errorf("failed to get %s property: %s", prop, err)
+		}
+		*value = out
+	}
+	return info, nil
+}
+
+// exportOutputs exports the device and boot details. When waiting for multiple devices,
+// each output holds one line per device, in the order of the serials.
+// The single serial output is only exported for a single device, as the following steps use it as a serial as is.
+func exportOutputs(ctx context.Context, cmdFactory adbmanager.CommandFactory, adb *adbmanager.Model, results []bootResult) error {
+	outputs := map[string][]string{}
+	for _, result := range results {
+		info, err := getDeviceInfo(ctx, adb, result.serial)
+		if err != nil {
+			return fmt.Errorf("device (%s): %s", result.serial, err)
+		}
+
+		outputs[serialsOutputKey] = append(outputs[serialsOutputKey], result.serial)
+		outputs[bootDurationOutputKey] = append(outputs[bootDurationOutputKey], strconv.Itoa(int(result.stats.Duration/time.Second)))
+		outputs[apiLevelOutputKey] = append(outputs[apiLevelOutputKey], info.apiLevel)
+		outputs[abiOutputKey] = append(outputs[abiOutputKey], info.abi)
+		outputs[modelOutputKey] = append(outputs[modelOutputKey], info.model)
+		outputs[bootRetriesOutputKey] = append(outputs[bootRetriesOutputKey], strconv.Itoa(result.stats.Retries))
+		outputs[serverRestartsOutputKey] = append(outputs[serverRestartsOutputKey], strconv.Itoa(result.stats.ServerRestarts))
+		outputs[restartsOutputKey] = append(outputs[restartsOutputKey], strconv.Itoa(result.emulatorRestarts))
+	}
+
+	keys := []string{serialsOutputKey, bootDurationOutputKey, apiLevelOutputKey, abiOutputKey, modelOutputKey, bootRetriesOutputKey, serverRestartsOutputKey, restartsOutputKey}
+	if len(results) == 1 {
+		outputs[serialOutputKey] = outputs[serialsOutputKey]
+		keys = append([]string{serialOutputKey}, keys...)
+	}
+
+	for _, key := range keys {
+		value := strings.Join(outputs[key], "\n")
+		if err := exportEnv(ctx, cmdFactory, key, value); err != nil {
+			return fmt.Errorf("failed to export %s: %s", key, err)
+		}
+		logger.Printf("%s=%s", key, strings.ReplaceAll(value, "\n", ", "))
+	}
+
+	return nil
+}
+
+func exportEnv(ctx context.Context, cmdFactory adbmanager.CommandFactory, key, value string) error {
+	cmd := cmdFactory.Create(ctx, "envman", []string{"add", "--key", key, "--value", value}, nil)
+	if out, err := cmd.RunAndReturnTrimmedCombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, out)
+	}
+	return nil
+}
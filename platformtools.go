@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-io/go-android/v2/sdk"
+	"github.com/bitrise-io/go-utils/v2/command"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+// installTimeout limits the platform-tools download and install.
+const installTimeout = 10 * time.Minute
+
+// installPlatformTools installs platform-tools into the SDK with sdkmanager and returns the path of the installed adb.
+// If repository is set, the packages are installed from that SDK repository mirror (a URL or a local directory).
+func installPlatformTools(ctx context.Context, androidSdk *sdk.Model, cmdFactory adbmanager.CommandFactory, repository string) (string, error) {
+	toolsDir, err := androidSdk.CmdlineToolsPath()
+	if err != nil {
+		return "", err
+	}
+	sdkmanager := filepath.Join(toolsDir, "sdkmanager")
+
+	var env []string
+	if repository != "" {
+		baseURL, err := repositoryBaseURL(repository)
+		if err != nil {
+			return "", err
+		}
+		logger.Printf("Using SDK repository: %s", baseURL)
+		// sdkmanager looks up the repository manifests relative to this URL instead of dl.google.com.
+		env = append(env, "SDK_TEST_BASE_URL="+baseURL)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, installTimeout)
+	defer cancel()
+
+	logger.Printf("Installing platform-tools with %s...", sdkmanager)
+	var out bytes.Buffer
+	cmd := cmdFactory.Create(ctx, sdkmanager, []string{"--sdk_root=" + androidSdk.GetAndroidHome(), "platform-tools"}, &command.Opts{
+		// Accepts the license prompt, if the license is not accepted yet.
+		Stdin:  strings.NewReader(strings.Repeat("y\n", 10)),
+		Stdout: &out,
+		Stderr: &out,
+		Env:    env,
+	})
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %s", err, lastLines(out.String(), 20))
+	}
+
+	platformTools := filepath.Join(androidSdk.GetAndroidHome(), "platform-tools")
+	logger.Donef("Installed platform-tools %s to %s", platformToolsRevision(platformTools), platformTools)
+
+	return adbmanager.ResolveBinary([]adbmanager.BinaryCandidate{
+		adbmanager.PlatformToolsCandidate("installed platform-tools", androidSdk.GetAndroidHome()),
+	}, logger)
+}
+
+// repositoryBaseURL turns a local mirror directory into a file URL, sdkmanager requires a trailing slash.
+func repositoryBaseURL(repository string) (string, error) {
+	if !strings.Contains(repository, "://") {
+		pth, err := filepath.Abs(repository)
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Stat(pth); err != nil {
+			return "", fmt.Errorf("SDK repository mirror: %w", err)
+		}
+		repository = (&url.URL{Scheme: "file", Path: pth}).String()
+	}
+	return strings.TrimSuffix(repository, "/") + "/", nil
+}
+
+// platformToolsRevision returns Pkg.Revision of the installed package.
+func platformToolsRevision(platformToolsDir string) string {
+	content, err := os.ReadFile(filepath.Join(platformToolsDir, "source.properties"))
+	if err != nil {
+		return "(unknown version)"
+	}
+
+	// Example line: Pkg.Revision=34.0.5
+	for _, line := range strings.Split(string(content), "\n") {
+		if revision, ok := strings.CutPrefix(strings.TrimSpace(line), "Pkg.Revision="); ok {
+			return revision
+		}
+	}
+	return "(unknown version)"
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
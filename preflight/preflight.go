@@ -0,0 +1,266 @@
+// Package preflight checks if the host is able to run the Android emulator with hardware acceleration,
+// so a misconfigured host is reported before waiting for a boot that would never finish in time.
+package preflight
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	kvmDevice   = "/dev/kvm"
+	cpuinfoPath = "/proc/cpuinfo"
+	meminfoPath = "/proc/meminfo"
+)
+
+// readWriteAccess is R_OK | W_OK of access(2), the syscall package doesn't define them.
+const readWriteAccess = 0x4 | 0x2
+
+// Problem is a failed preflight check with the steps to fix it.
+type Problem struct {
+	Check       string
+	Message     string
+	Remediation string
+	// Advisory problems might slow the emulator down, but they don't prevent it from booting.
+	Advisory bool
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s. %s", p.Check, p.Message, p.Remediation)
+}
+
+// AVDConfig is the hardware configuration of an AVD, from its config.ini.
+type AVDConfig struct {
+	Name     string
+	RAMMB    int
+	CPUCores int
+}
+
+// CheckHost checks the hardware acceleration support of the host, it doesn't need a running emulator.
+// The checks are specific to Linux hosts, nothing is reported on other platforms.
+func CheckHost() []Problem {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	var problems []Problem
+	if problem := checkKVM(); problem != nil {
+		problems = append(problems, *problem)
+	}
+	if problem := checkCPUFlags(); problem != nil {
+		problems = append(problems, *problem)
+	}
+	return problems
+}
+
+// CheckResources compares the memory and the CPU cores of the host with the total requirements of the AVDs.
+// The checks are specific to Linux hosts, nothing is reported on other platforms.
+func CheckResources(avds []AVDConfig) []Problem {
+	if runtime.GOOS != "linux" {
+		return nil
+	}
+
+	// The emulators are already running, the available memory doesn't include what they use.
+	hostRAMMB, err := totalMemoryMB()
+	if err != nil {
+		hostRAMMB = 0
+	}
+	return checkResources(avds, hostRAMMB, runtime.NumCPU())
+}
+
+func checkKVM() *Problem {
+	if _, err := os.Stat(kvmDevice); os.IsNotExist(err) {
+		return &Problem{
+			Check:       "KVM",
+			Message:     kvmDevice + " does not exist, the emulator can't use hardware acceleration",
+			Remediation: "Enable virtualization (VT-x/AMD-V) in the BIOS, or nested virtualization if the host is a VM, and load the kvm_intel or kvm_amd kernel module",
+		}
+	} else if err != nil {
+		return &Problem{Check: "KVM", Message: fmt.Sprintf("failed to check %s: %s", kvmDevice, err), Remediation: "Check the permissions of /dev"}
+	}
+
+	if err := syscall.Access(kvmDevice, readWriteAccess); err != nil {
+		return &Problem{
+			Check:       "KVM",
+			Message:     fmt.Sprintf("the current user can't access %s: %s", kvmDevice, err),
+			Remediation: "Add the user to the kvm group (sudo usermod -aG kvm $USER) or grant access with a udev rule: KERNEL==\"kvm\", GROUP=\"kvm\", MODE=\"0666\"",
+		}
+	}
+	return nil
+}
+
+func checkCPUFlags() *Problem {
+	// The vmx/svm flags only exist on x86 hosts.
+	if runtime.GOARCH != "amd64" && runtime.GOARCH != "386" {
+		return nil
+	}
+
+	file, err := os.Open(cpuinfoPath)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	// Example line: flags		: fpu vme de pse tsc msr pae mce cx8 apic sep mtrr ... vmx ...
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "flags" {
+			continue
+		}
+		for _, flag := range strings.Fields(value) {
+			if flag == "vmx" || flag == "svm" {
+				return nil
+			}
+		}
+		break
+	}
+
+	return &Problem{
+		Check:       "CPU virtualization",
+		Message:     "the CPU doesn't expose the virtualization extensions (no vmx or svm flag in " + cpuinfoPath + ")",
+		Remediation: "Enable VT-x/AMD-V in the BIOS, or nested virtualization if the host is a VM",
+	}
+}
+
+// checkResources compares the host resources with the AVDs, an unknown (zero) host memory is not checked.
+func checkResources(avds []AVDConfig, hostRAMMB, hostCores int) []Problem {
+	var names []string
+	ramMB, cores := 0, 0
+	for _, avd := range avds {
+		names = append(names, avd.Name)
+		ramMB += avd.RAMMB
+		cores += avd.CPUCores
+	}
+
+	var problems []Problem
+	if hostRAMMB > 0 && ramMB > hostRAMMB {
+		problems = append(problems, Problem{
+			Check:       "Memory",
+			Message:     fmt.Sprintf("the host has %d MB of memory, the AVDs (%s) are configured for %d MB", hostRAMMB, strings.Join(names, ", "), ramMB),
+			Remediation: "Lower hw.ramSize in the AVD config, start fewer emulators or use a machine with more memory",
+		})
+	}
+	// Running more virtual CPUs than host cores works, the emulators only compete for the cores.
+	if cores > hostCores {
+		problems = append(problems, Problem{
+			Check:       "CPU cores",
+			Message:     fmt.Sprintf("the host has %d CPU cores, the AVDs (%s) are configured for %d", hostCores, strings.Join(names, ", "), cores),
+			Remediation: "Lower hw.cpu.ncore in the AVD config, start fewer emulators or use a machine with more cores",
+			Advisory:    true,
+		})
+	}
+	return problems
+}
+
+func totalMemoryMB() (int, error) {
+	content, err := os.ReadFile(meminfoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	// Example line: MemTotal:       16318412 kB
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(line, "MemTotal:"); ok {
+			kb, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), " kB"))
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal (%s): %w", value, err)
+			}
+			return kb / 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("MemTotal not found in %s", meminfoPath)
+}
+
+// ReadAVDConfig reads the hardware configuration of the AVD from the AVD home, which is looked up the same way
+// as the emulator does: $ANDROID_AVD_HOME, $ANDROID_EMULATOR_HOME/avd, $ANDROID_SDK_HOME/.android/avd, ~/.android/avd.
+func ReadAVDConfig(name string) (AVDConfig, error) {
+	for _, avdHome := range avdHomes() {
+		pth := filepath.Join(avdHome, name+".avd", "config.ini")
+		// <name>.ini points to the AVD directory, if it is not in the AVD home.
+		if ini, err := readIni(filepath.Join(avdHome, name+".ini")); err == nil && ini["path"] != "" {
+			pth = filepath.Join(ini["path"], "config.ini")
+		}
+
+		config, err := readIni(pth)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return AVDConfig{}, err
+		}
+
+		avd := AVDConfig{Name: name, CPUCores: 1}
+		if avd.RAMMB, err = parseRAMSize(config["hw.ramSize"]); err != nil {
+			return AVDConfig{}, err
+		}
+		if cores, err := strconv.Atoi(config["hw.cpu.ncore"]); err == nil {
+			avd.CPUCores = cores
+		}
+		return avd, nil
+	}
+	return AVDConfig{}, fmt.Errorf("config of AVD (%s) not found in %s", name, strings.Join(avdHomes(), ", "))
+}
+
+func avdHomes() []string {
+	var homes []string
+	if dir := os.Getenv("ANDROID_AVD_HOME"); dir != "" {
+		homes = append(homes, dir)
+	}
+	if dir := os.Getenv("ANDROID_EMULATOR_HOME"); dir != "" {
+		homes = append(homes, filepath.Join(dir, "avd"))
+	}
+	if dir := os.Getenv("ANDROID_SDK_HOME"); dir != "" {
+		homes = append(homes, filepath.Join(dir, ".android", "avd"))
+	}
+	if dir, err := os.UserHomeDir(); err == nil {
+		homes = append(homes, filepath.Join(dir, ".android", "avd"))
+	}
+	return homes
+}
+
+func readIni(pth string) (map[string]string, error) {
+	content, err := os.ReadFile(pth)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return values, nil
+}
+
+// parseRAMSize parses hw.ramSize, which is in MB unless it has a unit (e.g. 2048, 2048M, 2G).
+func parseRAMSize(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := 1
+	number := strings.TrimSuffix(strings.ToUpper(value), "B")
+	switch {
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1024
+		number = strings.TrimSuffix(number, "G")
+	case strings.HasSuffix(number, "M"):
+		number = strings.TrimSuffix(number, "M")
+	}
+
+	size, err := strconv.Atoi(strings.TrimSpace(number))
+	if err != nil {
+		return 0, fmt.Errorf("invalid hw.ramSize (%s): %w", value, err)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,97 @@
+package preflight
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRAMSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "2048", want: 2048},
+		{value: "2048M", want: 2048},
+		{value: "2048MB", want: 2048},
+		{value: "2G", want: 2048},
+		{value: "4gb", want: 4096},
+		{value: "1.5G", wantErr: true},
+		{value: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseRAMSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseRAMSize(%q) error = %v, wantErr %t", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseRAMSize(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadIni(t *testing.T) {
+	pth := filepath.Join(t.TempDir(), "config.ini")
+	content := "avd.ini.encoding=UTF-8\nhw.ramSize = 2048M\nhw.cpu.ncore=4\n\n# comment without a value\npath=/home/user/.android/avd/Pixel.avd\n"
+	if err := os.WriteFile(pth, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readIni(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"avd.ini.encoding": "UTF-8",
+		"hw.ramSize":       "2048M",
+		"hw.cpu.ncore":     "4",
+		"path":             "/home/user/.android/avd/Pixel.avd",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIni() = %v, want %v", got, want)
+	}
+
+	if _, err := readIni(filepath.Join(t.TempDir(), "missing.ini")); !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error for a missing file, got: %v", err)
+	}
+}
+
+func TestCheckResources(t *testing.T) {
+	avds := []AVDConfig{
+		{Name: "Pixel_A", RAMMB: 2048, CPUCores: 2},
+		{Name: "Pixel_B", RAMMB: 4096, CPUCores: 4},
+	}
+
+	tests := []struct {
+		name      string
+		hostRAMMB int
+		hostCores int
+		want      []string
+		advisory  []bool
+	}{
+		{name: "enough resources", hostRAMMB: 16384, hostCores: 8},
+		{name: "not enough memory", hostRAMMB: 4096, hostCores: 8, want: []string{"Memory"}, advisory: []bool{false}},
+		{name: "not enough cores", hostRAMMB: 16384, hostCores: 4, want: []string{"CPU cores"}, advisory: []bool{true}},
+		{name: "unknown memory", hostRAMMB: 0, hostCores: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := checkResources(avds, tt.hostRAMMB, tt.hostCores)
+
+			var checks []string
+			var advisory []bool
+			for _, problem := range problems {
+				checks = append(checks, problem.Check)
+				advisory = append(advisory, problem.Advisory)
+			}
+			if !reflect.DeepEqual(checks, tt.want) || !reflect.DeepEqual(advisory, tt.advisory) {
+				t.Errorf("checkResources() = %v (advisory: %v), want %v (advisory: %v)", checks, advisory, tt.want, tt.advisory)
+			}
+		})
+	}
+}
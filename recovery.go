@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/emulator"
+)
+
+// emulatorRecovery restarts emulators whose boot stalled.
+type emulatorRecovery struct {
+	attempts int
+	flags    []string
+	logDir   string
+}
+
+func newEmulatorRecovery(attempts int, flags, logDir string) *emulatorRecovery {
+	if logDir == "" {
+		logDir = os.TempDir()
+	}
+	return &emulatorRecovery{
+		attempts: attempts,
+		flags:    strings.Fields(flags),
+		logDir:   logDir,
+	}
+}
+
+// waitForDevice waits for the device, restarting the emulator with its original command line if the boot stalls,
+// until the restart attempts run out.
+func (r *emulatorRecovery) waitForDevice(ctx context.Context, adb *adbmanager.Model, serial string, opts adbmanager.WaitOptions) bootResult {
+	timeline := adbmanager.NewTimeline(serial)
+	opts.Timeline = timeline
+	result := bootResult{serial: serial, stats: adbmanager.BootStats{Timeline: timeline}}
+	startTime := time.Now()
+
+	// The command line has to be read before the boot, a crashed emulator process can't be inspected anymore.
+	process, err := emulator.FindProcess(serial)
+	if err != nil {
+		logger.Warnf("Emulator restart is not available for %s: %s", serial, err)
+	}
+
+	for {
+		stats, err := adb.WaitForDevice(ctx, serial, opts)
+		result.stats.Retries += stats.Retries
+		result.stats.ServerRestarts += stats.ServerRestarts
+		result.stats.Duration = time.Since(startTime)
+		result.err = err
+
+		if err == nil || !errors.Is(err, adbmanager.ErrBootStalled) || process == nil || result.emulatorRestarts >= r.attempts {
+			return result
+		}
+
+		result.emulatorRestarts++
+		logger.Warnf("%s", err)
+		logger.Warnf("Restarting emulator (%s), attempt %d/%d...", serial, result.emulatorRestarts, r.attempts)
+
+		timeline.MarkRestart()
+		if err := emulator.Kill(ctx, serial, process); err != nil {
+			result.err = fmt.Errorf("%w, failed to stop the emulator: %s", result.err, err)
+			return result
+		}
+
+		logPth := filepath.Join(r.logDir, fmt.Sprintf("emulator-%s-restart-%d.log", strings.ReplaceAll(serial, ":", "_"), result.emulatorRestarts))
+		relaunched, err := emulator.Launch(process, r.flags, logPth)
+		if err != nil {
+			result.err = fmt.Errorf("%w, failed to relaunch the emulator: %s", result.err, err)
+			return result
+		}
+
+		logger.Printf("Emulator relaunched (pid: %d, log: %s): %s", relaunched.PID, logPth, strings.Join(relaunched.Args, " "))
+		process = relaunched
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+const (
+	settingsProfileNone    = "none"
+	settingsProfileTesting = "testing"
+)
+
+// testingSettings disable the distractions of UI tests: animations, screen timeout, the soft keyboard
+// and the immersive mode confirmation dialog.
+var testingSettings = []adbmanager.Setting{
+	{Namespace: "global", Key: "window_animation_scale", Value: "0"},
+	{Namespace: "global", Key: "transition_animation_scale", Value: "0"},
+	{Namespace: "global", Key: "animator_duration_scale", Value: "0"},
+	// Stay awake while plugged in to AC, USB or wireless charger.
+	{Namespace: "global", Key: "stay_on_while_plugged_in", Value: "7"},
+	{Namespace: "secure", Key: "show_ime_with_hard_keyboard", Value: "0"},
+	{Namespace: "secure", Key: "immersive_mode_confirmations", Value: "confirmed"},
+}
+
+var settingsNamespaces = []string{"global", "secure", "system"}
+
+// settingKeyPattern matches the keys of the settings provider, e.g. window_animation_scale.
+var settingKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// parseSettings parses `namespace:key=value` lines.
+func parseSettings(lines []string) ([]adbmanager.Setting, error) {
+	var settings []adbmanager.Setting
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		namespace, keyValue, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid setting (%s), expected format: namespace:key=value", line)
+		}
+		key, value, ok := strings.Cut(keyValue, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid setting (%s), expected format: namespace:key=value", line)
+		}
+
+		namespace = strings.TrimSpace(namespace)
+		if !isSettingsNamespace(namespace) {
+			return nil, fmt.Errorf("invalid setting (%s), namespace should be one of: %s", line, strings.Join(settingsNamespaces, ", "))
+		}
+
+		key = strings.TrimSpace(key)
+		if !settingKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid setting (%s), key can only contain letters, digits, underscores and dots", line)
+		}
+
+		settings = append(settings, adbmanager.Setting{
+			Namespace: namespace,
+			Key:       key,
+			Value:     strings.TrimSpace(value),
+		})
+	}
+	return settings, nil
+}
+
+func isSettingsNamespace(namespace string) bool {
+	for _, ns := range settingsNamespaces {
+		if ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func settingsToApply(profile string, extraSettings []adbmanager.Setting) []adbmanager.Setting {
+	var settings []adbmanager.Setting
+	if profile == settingsProfileTesting {
+		settings = append(settings, testingSettings...)
+	}
+	return append(settings, extraSettings...)
+}
+
+// applySettings writes the settings on the device and returns the ones that could not be applied.
+func applySettings(ctx context.Context, adb *adbmanager.Model, serial string, settings []adbmanager.Setting) []string {
+	var failures []string
+	for _, setting := range settings {
+		if err := adb.PutSetting(ctx, serial, setting); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", setting, err))
+			continue
+		}
+		logger.Printf("- %s", setting)
+	}
+	return failures
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+func TestParseSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		want    []adbmanager.Setting
+		wantErr bool
+	}{
+		{
+			name:  "valid",
+			lines: []string{"global:window_animation_scale=0", "", " secure : show_ime_with_hard_keyboard = 1 ", "system:font.scale=My Font"},
+			want: []adbmanager.Setting{
+				{Namespace: "global", Key: "window_animation_scale", Value: "0"},
+				{Namespace: "secure", Key: "show_ime_with_hard_keyboard", Value: "1"},
+				{Namespace: "system", Key: "font.scale", Value: "My Font"},
+			},
+		},
+		{name: "missing namespace", lines: []string{"window_animation_scale=0"}, wantErr: true},
+		{name: "missing value", lines: []string{"global:window_animation_scale"}, wantErr: true},
+		{name: "unknown namespace", lines: []string{"device:window_animation_scale=0"}, wantErr: true},
+		{name: "key with spaces", lines: []string{"global:window animation=0"}, wantErr: true},
+		{name: "key with shell metacharacters", lines: []string{"global:a;reboot=0"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSettings(tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSettings() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSettings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/bitrise-steplib/steps-wait-for-android-emulator/adbmanager"
+)
+
+const timelineFileName = "emulator-boot-timeline.json"
+
+func printTimelines(results []bootResult) {
+	for _, result := range results {
+		if result.stats.Timeline == nil {
+			continue
+		}
+		logger.Println()
+		result.stats.Timeline.Print(logger)
+	}
+}
+
+// saveTimelines writes the boot timeline of every device into a JSON file in dir and returns its path.
+func saveTimelines(results []bootResult, dir string) (string, error) {
+	timelines := []*adbmanager.Timeline{}
+	for _, result := range results {
+		if result.stats.Timeline != nil {
+			timelines = append(timelines, result.stats.Timeline)
+		}
+	}
+
+	content, err := json.MarshalIndent(timelines, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	pth := filepath.Join(dir, timelineFileName)
+	if err := os.WriteFile(pth, content, 0644); err != nil {
+		return "", err
+	}
+	return pth, nil
+}